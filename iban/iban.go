@@ -0,0 +1,192 @@
+// Package iban validates and formats International Bank Account Numbers (ISO 13616) and Business Identifier Codes (ISO 9362).
+//
+// Only IBANs of the SEPA countries are known.
+package iban
+
+import (
+	"errors"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/language"
+)
+
+var (
+	ErrCountry  = errors.New("unknown or non-SEPA country code")
+	ErrLength   = errors.New("invalid length")
+	ErrFormat   = errors.New("invalid format")
+	ErrChecksum = errors.New("invalid checksum")
+)
+
+// BBAN structures of the SEPA countries, according to the SWIFT IBAN registry. Each group consists of a length and a character type:
+//
+//	n: digits
+//	a: upper case letters
+//	c: upper case letters and digits
+var formats = map[string]string{
+	// EU
+	"AT": "5n11n",
+	"BE": "3n7n2n",
+	"BG": "4a4n2n8c",
+	"CY": "3n5n16c",
+	"CZ": "4n6n10n",
+	"DE": "8n10n",
+	"DK": "4n9n1n",
+	"EE": "2n2n11n1n",
+	"ES": "4n4n1n1n10n",
+	"FI": "3n11n",
+	"FR": "5n5n11c2n",
+	"GR": "3n4n16c",
+	"HR": "7n10n",
+	"HU": "3n4n1n15n1n",
+	"IE": "4a6n8n",
+	"IT": "1a5n5n12c",
+	"LT": "5n11n",
+	"LU": "3n13c",
+	"LV": "4a13c",
+	"MT": "4a5n18c",
+	"NL": "4a10n",
+	"PL": "8n16n",
+	"PT": "4n4n11n2n",
+	"RO": "4a16c",
+	"SE": "3n16n1n",
+	"SI": "5n8n2n",
+	"SK": "4n6n10n",
+	// non-EU
+	"AD": "4n4n12c",
+	"AL": "8n16c",
+	"CH": "5n12c",
+	"GB": "4a6n8n",
+	"GI": "4a15c",
+	"IS": "4n2n6n10n",
+	"LI": "5n12c",
+	"MC": "5n5n11c2n",
+	"MD": "2c18c",
+	"ME": "3n13n2n",
+	"MK": "3n10c2n",
+	"NO": "4n6n1n",
+	"RS": "3n13n2n",
+	"SM": "1a5n5n12c",
+	"VA": "3n15n",
+}
+
+// Normalize removes whitespace and converts iban to upper case. Use it on user input before calling Validate.
+func Normalize(iban string) string {
+	var result strings.Builder
+	result.Grow(len(iban))
+	for _, r := range iban {
+		if !unicode.IsSpace(r) {
+			result.WriteRune(unicode.ToUpper(r))
+		}
+	}
+	return result.String()
+}
+
+// Validate checks the country code, the length, the BBAN structure and the mod-97 checksum of an IBAN in electronic format (upper case, without whitespace).
+func Validate(iban string) error {
+	if len(iban) < 4 {
+		return ErrLength
+	}
+	format, ok := formats[iban[:2]]
+	if !ok {
+		return ErrCountry
+	}
+	if !matches(iban[2:4], "2n") {
+		return ErrFormat
+	}
+	bban := iban[4:]
+	if len(bban) != length(format) {
+		return ErrLength
+	}
+	if !matches(bban, format) {
+		return ErrFormat
+	}
+	if Mod97(bban+iban[:4]) != 1 {
+		return ErrChecksum
+	}
+	return nil
+}
+
+// Format returns the normalized iban in groups of four characters, like "DE89 3704 0044 0532 0130 00", for display. It does not validate iban.
+func Format(iban string) string {
+	iban = Normalize(iban)
+	var result strings.Builder
+	for i, r := range iban {
+		if i > 0 && i%4 == 0 {
+			result.WriteByte(' ')
+		}
+		result.WriteRune(r)
+	}
+	return result.String()
+}
+
+// ValidateBIC checks the format of a BIC: four letters (institution), two letters (country), two letters or digits (location) and an optional branch code of three letters or digits.
+// The country code must be an ISO 3166-1 country code. Unlike IBANs, it is not restricted to SEPA countries, because BICs exist for countries and territories without an IBAN format, like Jersey.
+func ValidateBIC(bic string) error {
+	if len(bic) != 8 && len(bic) != 11 {
+		return ErrLength
+	}
+	if !matches(bic, "4a2a2c") || (len(bic) == 11 && !matches(bic[8:], "3c")) {
+		return ErrFormat
+	}
+	if region, err := language.ParseRegion(bic[4:6]); err != nil || !region.IsCountry() || region.Canonicalize().String() != bic[4:6] {
+		return ErrCountry
+	}
+	return nil
+}
+
+// length returns the total length of a structure like "8n10n".
+func length(format string) int {
+	var total, n int
+	for _, r := range format {
+		if '0' <= r && r <= '9' {
+			n = n*10 + int(r-'0')
+		} else {
+			total += n
+			n = 0
+		}
+	}
+	return total
+}
+
+// matches checks whether s begins with the structure described by format, like "8n10n".
+func matches(s string, format string) bool {
+	var pos, n int
+	for _, r := range format {
+		if '0' <= r && r <= '9' {
+			n = n*10 + int(r-'0')
+			continue
+		}
+		if pos+n > len(s) {
+			return false
+		}
+		for _, c := range s[pos : pos+n] {
+			digit := '0' <= c && c <= '9'
+			letter := 'A' <= c && c <= 'Z'
+			switch {
+			case r == 'n' && !digit, r == 'a' && !letter, r == 'c' && !digit && !letter:
+				return false
+			}
+		}
+		pos += n
+		n = 0
+	}
+	return true
+}
+
+// Mod97 converts letters to numbers (A = 10, ..., Z = 35) and returns the remainder of the resulting number divided by 97, as specified in ISO 7064.
+// It is used by IBANs and by ISO 11649 creditor references. It returns -1 if s contains invalid characters, including lower case letters.
+func Mod97(s string) int {
+	var remainder int
+	for _, r := range s {
+		switch {
+		case '0' <= r && r <= '9':
+			remainder = (remainder*10 + int(r-'0')) % 97
+		case 'A' <= r && r <= 'Z':
+			remainder = (remainder*100 + int(r-'A'+10)) % 97
+		default:
+			return -1
+		}
+	}
+	return remainder
+}
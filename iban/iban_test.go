@@ -0,0 +1,72 @@
+package iban
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		iban string
+		want error
+	}{
+		{"AT611904300234573201", nil},
+		{"BE68539007547034", nil},
+		{"CH9300762011623852957", nil},
+		{"DE89370400440532013000", nil},
+		{"FR1420041010050500013M02606", nil},
+		{"GB82WEST12345698765432", nil},
+		{"IT60X0542811101000000123456", nil},
+		{"MT84MALT011000012345MTLCAST001S", nil},
+		{"NL91ABNA0417164300", nil},
+		{"NO9386011117947", nil},
+		{"", ErrLength},
+		{"US12345678901234", ErrCountry},
+		{"de89370400440532013000", ErrCountry},
+		{"DE8937040044053201300", ErrLength},
+		{"DE893704004405320130000", ErrLength},
+		{"DE0537040044053201300A", ErrFormat}, // valid checksum, but letter in German BBAN
+		{"DEXX370400440532013000", ErrFormat},
+		{"DE88370400440532013000", ErrChecksum},
+		{"NO698601111794", ErrLength},
+	}
+	for _, test := range tests {
+		if got := Validate(test.iban); !errors.Is(got, test.want) {
+			t.Fatalf("%s: got %v, want %v", test.iban, got, test.want)
+		}
+	}
+}
+
+func TestNormalizeFormat(t *testing.T) {
+	if got := Normalize(" de89 3704 0044\t0532 0130 00 "); got != "DE89370400440532013000" {
+		t.Fatalf("got %q", got)
+	}
+	if got := Format("DE89370400440532013000"); got != "DE89 3704 0044 0532 0130 00" {
+		t.Fatalf("got %q", got)
+	}
+}
+
+func TestValidateBIC(t *testing.T) {
+	tests := []struct {
+		bic  string
+		want error
+	}{
+		{"COBADEFF", nil},
+		{"COBADEFFXXX", nil},
+		{"BPOTBEB1", nil},
+		{"COBADEF", ErrLength},
+		{"COBADEFFX", ErrLength},
+		{"C0BADEFF", ErrFormat},
+		{"COBADEFFxxx", ErrFormat},
+		{"COBAJESH", nil},
+		{"COBAUSFF", nil},
+		{"COBAEUFF", ErrCountry},
+		{"COBAUKFF", ErrCountry},
+		{"COBAZZFF", ErrCountry},
+	}
+	for _, test := range tests {
+		if got := ValidateBIC(test.bic); !errors.Is(got, test.want) {
+			t.Fatalf("%s: got %v, want %v", test.bic, got, test.want)
+		}
+	}
+}
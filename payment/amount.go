@@ -0,0 +1,144 @@
+package payment
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// An Amount is a monetary amount in the minor unit of an ISO 4217 currency, e. g. cents for EUR and yen for JPY.
+type Amount struct {
+	Minor    int
+	Currency string // ISO 4217, like "EUR"
+}
+
+// EUR returns an amount in euro cents.
+func EUR(cents int) Amount {
+	return Amount{cents, "EUR"}
+}
+
+// ISO 4217 currencies whose minor unit is not 1/100
+var exponents = map[string]int{
+	"BHD": 3,
+	"BIF": 0,
+	"CLP": 0,
+	"DJF": 0,
+	"GNF": 0,
+	"IQD": 3,
+	"ISK": 0,
+	"JOD": 3,
+	"JPY": 0,
+	"KMF": 0,
+	"KRW": 0,
+	"KWD": 3,
+	"LYD": 3,
+	"OMR": 3,
+	"PYG": 0,
+	"RWF": 0,
+	"TND": 3,
+	"UGX": 0,
+	"UYI": 0,
+	"VND": 0,
+	"VUV": 0,
+	"XAF": 0,
+	"XOF": 0,
+	"XPF": 0,
+}
+
+// Exponent returns the number of decimal places of the minor unit of an ISO 4217 currency, like 2 for EUR and 0 for JPY.
+func Exponent(currency string) int {
+	if exp, ok := exponents[currency]; ok {
+		return exp
+	}
+	return 2
+}
+
+// FromFloat converts a decimal value, like an exchange rate product, into an Amount. It rounds to the minor unit.
+func FromFloat(value float64, currency string) Amount {
+	return Amount{int(math.Round(value * math.Pow10(Exponent(currency)))), currency}
+}
+
+// ParseAmount parses a non-negative decimal amount like "12.34" exactly. It rejects more decimal places than the currency has.
+func ParseAmount(decimal, currency string) (Amount, error) {
+	exp := Exponent(currency)
+	units, fraction, _ := strings.Cut(strings.TrimSpace(decimal), ".")
+	if units == "" || !isDigits(units) || !isDigits(fraction) || len(fraction) > exp {
+		return Amount{}, fmt.Errorf("invalid %s amount: %s", currency, decimal)
+	}
+	fraction += strings.Repeat("0", exp-len(fraction))
+	minor, err := strconv.Atoi(units + fraction)
+	if err != nil {
+		return Amount{}, fmt.Errorf("invalid %s amount: %s", currency, decimal)
+	}
+	return Amount{minor, currency}, nil
+}
+
+// Decimal returns the amount as a decimal string without currency, like "12.34" or "1234" for JPY, which is accepted by payment provider APIs.
+func (a Amount) Decimal() string {
+	exp := Exponent(a.Currency)
+	if exp == 0 {
+		return strconv.Itoa(a.Minor)
+	}
+	minor := a.Minor
+	sign := ""
+	if minor < 0 {
+		sign = "-"
+		minor = -minor
+	}
+	pow := int(math.Pow10(exp))
+	return fmt.Sprintf("%s%d.%0*d", sign, minor/pow, exp, minor%pow)
+}
+
+// Float returns the amount in major units, like 12.34.
+func (a Amount) Float() float64 {
+	return float64(a.Minor) / math.Pow10(Exponent(a.Currency))
+}
+
+// String returns the amount like "12.34 CHF".
+func (a Amount) String() string {
+	return a.Decimal() + " " + a.Currency
+}
+
+// A CurrencyRepo is a PurchaseRepo which prices purchases in other currencies than EUR.
+//
+// If a PurchaseRepo implements it, the amounts passed to PaymentSettled and PaymentRefunded are in the minor unit of the purchase currency, and PurchaseSumCents should not be used.
+type CurrencyRepo interface {
+	PurchaseSum(purchaseID, paymentKey string) (Amount, error)
+}
+
+// PurchaseSum returns the sum of a purchase. If repo does not implement CurrencyRepo, the sum is in EUR.
+func PurchaseSum(repo PurchaseRepo, purchaseID, paymentKey string) (Amount, error) {
+	if currencyRepo, ok := repo.(CurrencyRepo); ok {
+		return currencyRepo.PurchaseSum(purchaseID, paymentKey)
+	}
+	cents, err := repo.PurchaseSumCents(purchaseID, paymentKey)
+	return EUR(cents), err
+}
+
+// eurSum returns the sum of a purchase in euro cents. It returns an error if the purchase is priced in another currency.
+func eurSum(repo PurchaseRepo, purchaseID, paymentKey string) (int, error) {
+	sum, err := PurchaseSum(repo, purchaseID, paymentKey)
+	if err != nil {
+		return 0, err
+	}
+	if sum.Currency != "EUR" {
+		return 0, fmt.Errorf("unsupported currency: %s", sum.Currency)
+	}
+	return sum.Minor, nil
+}
+
+// A CurrencyMethod is a Method which supports other currencies than EUR. Methods which don't implement it support EUR only.
+type CurrencyMethod interface {
+	Currencies() []string // ISO 4217 codes, nil means all currencies
+}
+
+// Supports returns true if the method supports the currency.
+func Supports(method Method, currency string) bool {
+	if currencyMethod, ok := method.(CurrencyMethod); ok {
+		currencies := currencyMethod.Currencies()
+		return currencies == nil || slices.Contains(currencies, currency)
+	}
+	return currency == "EUR"
+}
@@ -0,0 +1,135 @@
+package payment
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAmount(t *testing.T) {
+	tests := []struct {
+		decimal  string
+		currency string
+		minor    int
+		format   string
+	}{
+		{"12.34", "EUR", 1234, "12.34"},
+		{"12.3", "EUR", 1230, "12.30"},
+		{"12", "CHF", 1200, "12.00"},
+		{"0.05", "CHF", 5, "0.05"},
+		{"1234", "JPY", 1234, "1234"},
+		{"1.234", "KWD", 1234, "1.234"},
+	}
+	for _, test := range tests {
+		got, err := ParseAmount(test.decimal, test.currency)
+		if err != nil {
+			t.Fatalf("ParseAmount(%s, %s): %v", test.decimal, test.currency, err)
+		}
+		if got.Minor != test.minor || got.Currency != test.currency {
+			t.Fatalf("ParseAmount(%s, %s): got %v, want %d", test.decimal, test.currency, got, test.minor)
+		}
+		if format := got.Decimal(); format != test.format {
+			t.Fatalf("%v: got decimal %s, want %s", got, format, test.format)
+		}
+		if fromFloat := FromFloat(got.Float(), test.currency); fromFloat != got {
+			t.Fatalf("FromFloat(%f, %s): got %v, want %v", got.Float(), test.currency, fromFloat, got)
+		}
+	}
+
+	for _, invalid := range []struct{ decimal, currency string }{
+		{"12.345", "EUR"},
+		{"12.3", "JPY"},
+		{"-1", "EUR"},
+		{".5", "EUR"},
+		{"1,50", "EUR"},
+	} {
+		if _, err := ParseAmount(invalid.decimal, invalid.currency); err == nil {
+			t.Fatalf("ParseAmount(%s, %s): got no error", invalid.decimal, invalid.currency)
+		}
+	}
+
+	if got := (Amount{-1205, "EUR"}).Decimal(); got != "-12.05" {
+		t.Fatalf("got %s, want -12.05", got)
+	}
+}
+
+func TestSupports(t *testing.T) {
+	tests := []struct {
+		method   Method
+		currency string
+		want     bool
+	}{
+		{BTCPay{}, "CHF", true},
+		{PayPal{}, "CHF", true},
+		{PayPal{}, "KWD", false},
+		{SEPA{}, "EUR", true},
+		{SEPA{}, "CHF", false},
+		{Stripe{}, "JPY", true},
+		{Voucher{}, "EUR", true},
+		{Voucher{}, "CHF", false},
+	}
+	for _, test := range tests {
+		if got := Supports(test.method, test.currency); got != test.want {
+			t.Fatalf("Supports(%s, %s): got %t, want %t", test.method.ID(), test.currency, got, test.want)
+		}
+	}
+}
+
+// chfRepo prices purchases in Swiss francs
+type chfRepo struct {
+	*testRepo
+}
+
+func (repo chfRepo) PurchaseSum(purchaseID, paymentKey string) (Amount, error) {
+	return Amount{repo.sumCents, "CHF"}, nil
+}
+
+func TestPurchaseSum(t *testing.T) {
+	repo := &testRepo{sumCents: 1234}
+	if sum, _ := PurchaseSum(repo, "ABC", "key"); sum != EUR(1234) {
+		t.Fatalf("got %v, want 12.34 EUR", sum)
+	}
+	if sum, _ := PurchaseSum(chfRepo{repo}, "ABC", "key"); sum != (Amount{1234, "CHF"}) {
+		t.Fatalf("got %v, want 12.34 CHF", sum)
+	}
+	if _, err := eurSum(chfRepo{repo}, "ABC", "key"); err == nil {
+		t.Fatal("eurSum: got no error for CHF purchase")
+	}
+}
+
+func TestStripeCurrency(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /v1/checkout/sessions", func(w http.ResponseWriter, r *http.Request) {
+		if r.PostFormValue("line_items[0][price_data][currency]") != "chf" || r.PostFormValue("line_items[0][price_data][unit_amount]") != "1234" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.Write([]byte(`{"id": "cs_1", "url": "https://checkout.stripe.com/c/pay/cs_1"}`))
+	})
+	mux.HandleFunc("GET /v1/checkout/sessions/{id}", func(w http.ResponseWriter, r *http.Request) {
+		currency := "chf"
+		if r.PathValue("id") == "cs_eur" {
+			currency = "eur"
+		}
+		w.Write([]byte(`{"id": "` + r.PathValue("id") + `", "amount_total": 1234, "client_reference_id": "ABC:key", "currency": "` + currency + `", "payment_intent": "pi_1", "payment_status": "paid"}`))
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	repo := &testRepo{sumCents: 1234}
+	handler := Stripe{
+		APIURL:    srv.URL,
+		SecretKey: "sk_test",
+		Purchases: chfRepo{repo},
+	}.Handler()
+
+	if w := serve(handler, http.MethodPost, "/payment/stripe/create-session", "purchase-id=ABC&payment-key=key"); w.Code != http.StatusSeeOther {
+		t.Fatalf("create session: got status %d", w.Code)
+	}
+
+	serve(handler, http.MethodGet, "/payment/stripe/redirect?session=cs_eur", "")
+	repo.check(t)
+
+	serve(handler, http.MethodGet, "/payment/stripe/redirect?session=cs_chf", "")
+	repo.check(t, "settled ABC:key Stripe pi_1 1234 false", "paid ABC:key Stripe")
+}
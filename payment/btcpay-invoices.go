@@ -0,0 +1,162 @@
+package payment
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// An InvoiceStore remembers the latest BTCPay invoice of a purchase, so customers who reload the payment page are redirected to the same invoice.
+// Implementations must be safe for concurrent use. Get must not return invoices which are older than the max age of the store.
+type InvoiceStore interface {
+	Get(reference string) (invoiceID string, ok bool, err error)
+	Set(reference, invoiceID string) error
+}
+
+// MemoryInvoiceStore is an InvoiceStore which is lost on restart.
+type MemoryInvoiceStore struct {
+	invoices map[string]createdInvoice // key: reference
+	lock     sync.Mutex
+	maxAge   time.Duration
+}
+
+type createdInvoice struct {
+	ID   string
+	Time int64
+}
+
+func NewMemoryInvoiceStore(maxAge time.Duration) *MemoryInvoiceStore {
+	return &MemoryInvoiceStore{
+		invoices: make(map[string]createdInvoice),
+		maxAge:   maxAge,
+	}
+}
+
+func (store *MemoryInvoiceStore) Get(reference string) (string, bool, error) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	invoice, ok := store.invoices[reference]
+	if !ok || invoice.Time < time.Now().Add(-store.maxAge).Unix() {
+		return "", false, nil
+	}
+	return invoice.ID, true, nil
+}
+
+// Set also removes expired invoices, so the store does not grow forever.
+func (store *MemoryInvoiceStore) Set(reference, invoiceID string) error {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	threshold := time.Now().Add(-store.maxAge).Unix()
+	for ref, invoice := range store.invoices {
+		if invoice.Time < threshold {
+			delete(store.invoices, ref)
+		}
+	}
+
+	store.invoices[reference] = createdInvoice{
+		ID:   invoiceID,
+		Time: time.Now().Unix(),
+	}
+	return nil
+}
+
+// SQLiteInvoiceStore is an InvoiceStore which survives restarts.
+type SQLiteInvoiceStore struct {
+	sqldb  *sql.DB
+	maxAge time.Duration
+	stop   chan struct{}
+	clean  *sql.Stmt
+	get    *sql.Stmt
+	set    *sql.Stmt
+}
+
+// OpenSQLiteInvoiceStore opens or creates an SQLite database and starts a goroutine which removes expired invoices every hour. Close stops the goroutine.
+func OpenSQLiteInvoiceStore(fpath string, maxAge time.Duration) (*SQLiteInvoiceStore, error) {
+	sqldb, err := sql.Open("sqlite3", fpath+"?_busy_timeout=10000&_journal=WAL&_sync=NORMAL&cache=shared")
+	if err != nil {
+		return nil, fmt.Errorf("opening database %s: %v", fpath, err)
+	}
+
+	if _, err := sqldb.Exec(`
+		create table if not exists btcpay_invoices (
+			reference  text    primary key, -- purchase ID and payment key
+			invoice_id text    not null,
+			time       integer not null     -- unix timestamp
+		);
+	`); err != nil {
+		return nil, err
+	}
+
+	clean, err := sqldb.Prepare("delete from btcpay_invoices where time < ?")
+	if err != nil {
+		return nil, err
+	}
+	get, err := sqldb.Prepare("select invoice_id from btcpay_invoices where reference = ? and time >= ?")
+	if err != nil {
+		return nil, err
+	}
+	set, err := sqldb.Prepare("insert or replace into btcpay_invoices (reference, invoice_id, time) values (?, ?, ?)")
+	if err != nil {
+		return nil, err
+	}
+
+	store := &SQLiteInvoiceStore{
+		sqldb:  sqldb,
+		maxAge: maxAge,
+		stop:   make(chan struct{}),
+		clean:  clean,
+		get:    get,
+		set:    set,
+	}
+
+	go func() {
+		var ticker = time.NewTicker(1 * time.Hour)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				store.Cleanup()
+			case <-store.stop:
+				return
+			}
+		}
+	}()
+
+	return store, nil
+}
+
+// Close stops the cleanup goroutine and closes the database. It must be called only once.
+func (store *SQLiteInvoiceStore) Close() error {
+	close(store.stop)
+	return store.sqldb.Close()
+}
+
+// Cleanup removes expired invoices.
+func (store *SQLiteInvoiceStore) Cleanup() {
+	if _, err := store.clean.Exec(time.Now().Add(-store.maxAge).Unix()); err != nil {
+		log.Printf("error cleaning up btcpay invoice store: %v", err)
+	}
+}
+
+func (store *SQLiteInvoiceStore) Get(reference string) (string, bool, error) {
+	var invoiceID string
+	switch err := store.get.QueryRow(reference, time.Now().Add(-store.maxAge).Unix()).Scan(&invoiceID); err {
+	case nil:
+		return invoiceID, true, nil
+	case sql.ErrNoRows:
+		return "", false, nil
+	default:
+		return "", false, err
+	}
+}
+
+func (store *SQLiteInvoiceStore) Set(reference, invoiceID string) error {
+	_, err := store.set.Exec(reference, invoiceID, time.Now().Unix())
+	return err
+}
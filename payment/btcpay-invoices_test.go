@@ -0,0 +1,36 @@
+package payment
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestInvoiceStores(t *testing.T) {
+	sqliteStore, err := OpenSQLiteInvoiceStore(filepath.Join(t.TempDir(), "invoices.sqlite3"), time.Hour)
+	if err != nil {
+		t.Fatalf("opening db: %v", err)
+	}
+	defer sqliteStore.Close()
+
+	for _, store := range []InvoiceStore{NewMemoryInvoiceStore(time.Hour), sqliteStore} {
+		if _, ok, err := store.Get("ABC:key"); ok || err != nil {
+			t.Fatalf("got %t %v, want false nil", ok, err)
+		}
+		if err := store.Set("ABC:key", "invoice-1"); err != nil {
+			t.Fatalf("setting: %v", err)
+		}
+		if err := store.Set("ABC:key", "invoice-2"); err != nil {
+			t.Fatalf("setting: %v", err)
+		}
+		if got, ok, err := store.Get("ABC:key"); got != "invoice-2" || !ok || err != nil {
+			t.Fatalf("got %s %t %v, want invoice-2 true nil", got, ok, err)
+		}
+	}
+
+	expired := NewMemoryInvoiceStore(-time.Minute)
+	expired.Set("ABC:key", "invoice-1")
+	if _, ok, _ := expired.Get("ABC:key"); ok {
+		t.Fatalf("got expired invoice")
+	}
+}
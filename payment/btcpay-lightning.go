@@ -0,0 +1,233 @@
+package payment
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/dys2p/eco/httputil"
+	"github.com/dys2p/eco/lang"
+	"github.com/dys2p/go-btcpay"
+	qrcode "github.com/skip2/go-qrcode"
+)
+
+var btcpayLightningTmpl = template.Must(template.ParseFS(htmlfiles, "btcpay-lightning.html"))
+
+type btcpayLightningTmplData struct {
+	lang.Lang
+	AmountBTC   string
+	BOLT11      string
+	PaymentLink template.URL
+	QRImageSrc  string // base64
+	RedirectURL string
+	Status      Status
+	StatusURL   string
+}
+
+// BTCPayLightning creates BTCPay invoices which can be paid with Lightning only, and shows the BOLT11 invoice and its QR code on our site.
+// Unlike BTCPay, customers never visit the BTCPay checkout page, which is useful for Tor users.
+//
+// The payment page polls the invoice status and reloads when the invoice is paid or expired.
+// Payments are reported by the same webhook logic as BTCPay, with the method name "BTCPayLightning". If you use both methods with the same store, one webhook is enough.
+// Otherwise set up the BTCPay webhook with the URL "/payment/btcpay-lightning/webhook".
+type BTCPayLightning struct {
+	ExpirationMinutes int          // default: 15
+	Invoices          InvoiceStore // required, can be shared with BTCPay, the max age can be the maximum invoice expiration of BTCPay Server because the invoice status is checked
+	PaymentMethodID   string       // default: "BTC-LN", BTCPay Server before version 2 uses "BTC-LightningNetwork"
+	Store             btcpay.Store
+	Purchases         PurchaseRepo
+
+	ErrWebhook func(err error) http.Handler
+}
+
+func (ln BTCPayLightning) Handler() http.Handler {
+	var mux = http.NewServeMux()
+	mux.HandleFunc("GET  /payment/btcpay-lightning/invoice-status", ln.invoiceStatus)
+	mux.Handle("GET  /payment/btcpay-lightning/purchase-status", purchaseStatus(ln.Purchases))
+	mux.Handle("POST /payment/btcpay-lightning/webhook", httputil.HandlerFunc(BTCPay{
+		Store:      ln.Store,
+		Purchases:  ln.Purchases,
+		ErrWebhook: ln.ErrWebhook,
+	}.webhook))
+	return mux
+}
+
+// Currencies returns nil because BTCPay Server supports all fiat currencies of its rate provider.
+func (BTCPayLightning) Currencies() []string {
+	return nil
+}
+
+func (BTCPayLightning) ID() string {
+	return "btcpay-lightning"
+}
+
+func (BTCPayLightning) Name(l lang.Lang) string {
+	return l.Tr("Bitcoin Lightning")
+}
+
+// btcpayLightningSuffix is appended to the order ID of Lightning invoices, so the webhook can tell them from BTCPay invoices.
+const btcpayLightningSuffix = ":lightning"
+
+func (ln BTCPayLightning) paymentMethodID() string {
+	if ln.PaymentMethodID == "" {
+		return "BTC-LN"
+	}
+	return ln.PaymentMethodID
+}
+
+// PayHTML shows the existing Lightning invoice of the purchase, or creates a new one if there is none or if it has expired.
+func (ln BTCPayLightning) PayHTML(purchaseID, paymentKey, redirectURL string, l lang.Lang) (template.HTML, error) {
+	invoice, err := ln.invoice(purchaseID, paymentKey)
+	if err != nil {
+		log.Printf("error getting btcpay lightning invoice: %v", err)
+		return template.HTML("Error creating Lightning invoice. We have been notified and will fix it soon. Sorry for the inconvenience."), nil
+	}
+
+	data := btcpayLightningTmplData{
+		Lang:        l,
+		RedirectURL: redirectURL,
+		Status:      btcpayStatus(invoice.Status),
+		StatusURL:   "/payment/btcpay-lightning/invoice-status?" + url.Values{"purchase-id": {purchaseID}, "payment-key": {paymentKey}}.Encode(),
+	}
+
+	if data.Status == StatusPending {
+		paymentMethods, err := ln.Store.GetInvoicePaymentMethods(invoice.ID)
+		if err != nil {
+			log.Printf("error getting payment methods of btcpay invoice %s: %v", invoice.ID, err)
+			return template.HTML("Error getting Lightning invoice. Please try again in a minute."), nil
+		}
+		for _, method := range paymentMethods {
+			if method.PaymentMethodID == ln.paymentMethodID() {
+				data.AmountBTC = method.Amount
+				data.BOLT11 = method.Destination
+				data.PaymentLink = template.URL("lightning:" + method.Destination) // from our BTCPay Server, bypass the URL scheme filter
+			}
+		}
+		if data.BOLT11 == "" {
+			log.Printf("btcpay invoice %s has no %s payment method", invoice.ID, ln.paymentMethodID())
+			return template.HTML("Error getting Lightning invoice. Please try again in a minute."), nil
+		}
+
+		qrPNG, err := qrcode.Encode(strings.ToUpper("lightning:"+data.BOLT11), qrcode.Medium, -5) // uppercase results in a smaller QR code
+		if err != nil {
+			log.Printf("error creating lightning QR code: %v", err) // don't exit
+		}
+		data.QRImageSrc = base64.StdEncoding.EncodeToString(qrPNG)
+	}
+
+	buf := &bytes.Buffer{}
+	err = btcpayLightningTmpl.Execute(buf, data)
+	return template.HTML(buf.String()), err
+}
+
+func (BTCPayLightning) VerifiesAdult() bool {
+	return false
+}
+
+// invoice returns the stored invoice of the purchase, or creates a new one if there is none or if it has expired.
+func (ln BTCPayLightning) invoice(purchaseID, paymentKey string) (*btcpay.Invoice, error) {
+	reference := purchaseID + ":" + paymentKey + btcpayLightningSuffix // don't mix up with BTCPay invoices if the InvoiceStore is shared
+
+	if ln.Invoices == nil {
+		return nil, errors.New("InvoiceStore is nil")
+	}
+
+	invoiceID, ok, err := ln.Invoices.Get(reference)
+	if err != nil {
+		log.Printf("error getting btcpay invoice from store: %v", err) // not fatal, create a new invoice
+	}
+	if ok {
+		invoice, err := ln.Store.GetInvoice(invoiceID)
+		if err != nil {
+			return nil, fmt.Errorf("getting invoice %s: %w", invoiceID, err)
+		}
+		if status := btcpayStatus(invoice.Status); status != StatusExpired && status != StatusFailed {
+			return invoice, nil
+		}
+	}
+
+	sum, err := PurchaseSum(ln.Purchases, purchaseID, paymentKey)
+	if err != nil {
+		return nil, fmt.Errorf("getting purchase sum: %w", err)
+	}
+
+	invoiceRequest := &btcpay.InvoiceRequest{
+		Amount:   sum.Float(),
+		Currency: sum.Currency,
+	}
+	invoiceRequest.ExpirationMinutes = ln.ExpirationMinutes
+	if invoiceRequest.ExpirationMinutes == 0 {
+		invoiceRequest.ExpirationMinutes = 15
+	}
+	invoiceRequest.OrderID = reference
+	invoiceRequest.PaymentMethods = []string{ln.paymentMethodID()}
+	invoice, err := ln.Store.CreateInvoice(invoiceRequest)
+	if err != nil {
+		return nil, fmt.Errorf("creating invoice: %w", err)
+	}
+
+	if err := ln.Invoices.Set(reference, invoice.ID); err != nil {
+		log.Printf("error storing btcpay invoice: %v", err)
+	}
+	return invoice, nil
+}
+
+// invoiceStatus serves the status of the stored Lightning invoice of the purchase "?purchase-id=...&payment-key=..." as JSON, like {"status": "paid"}.
+func (ln BTCPayLightning) invoiceStatus(w http.ResponseWriter, r *http.Request) {
+	purchaseID := r.URL.Query().Get("purchase-id")
+	paymentKey := r.URL.Query().Get("payment-key")
+	if purchaseID == "" {
+		http.Error(w, "missing purchase id", http.StatusBadRequest)
+		return
+	}
+	if ln.Invoices == nil {
+		http.NotFound(w, r)
+		return
+	}
+	invoiceID, ok, err := ln.Invoices.Get(purchaseID + ":" + paymentKey + btcpayLightningSuffix)
+	switch {
+	case err != nil:
+		log.Printf("[%s] error getting btcpay invoice from store: %v", purchaseID+":"+paymentKey, err)
+		http.Error(w, "error getting invoice", http.StatusInternalServerError)
+		return
+	case !ok:
+		http.NotFound(w, r)
+		return
+	}
+	invoice, err := ln.Store.GetInvoice(url.PathEscape(invoiceID))
+	switch {
+	case errors.Is(err, btcpay.ErrNotFound):
+		http.NotFound(w, r)
+		return
+	case err != nil:
+		log.Printf("error getting btcpay invoice: %v", err)
+		http.Error(w, "error getting invoice", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(statusResponse{btcpayStatus(invoice.Status)})
+}
+
+// btcpayStatus maps a BTCPay invoice status to a Status.
+func btcpayStatus(invoiceStatus string) Status {
+	switch invoiceStatus {
+	case btcpay.InvoiceProcessing:
+		return StatusProcessing
+	case btcpay.InvoiceSettled:
+		return StatusPaid
+	case btcpay.InvoiceExpired:
+		return StatusExpired
+	case btcpay.InvoiceInvalid:
+		return StatusFailed
+	default:
+		return StatusPending
+	}
+}
@@ -0,0 +1,108 @@
+package payment
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dys2p/eco/lang"
+	"github.com/dys2p/go-btcpay"
+	"golang.org/x/text/language"
+	"golang.org/x/text/message"
+)
+
+func TestBTCPayLightning(t *testing.T) {
+	var lock sync.Mutex
+	var created int
+	status := btcpay.InvoiceNew
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /api/v1/stores/store-1/invoices", func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+		body, _ := io.ReadAll(r.Body)
+		if !strings.Contains(string(body), `"paymentMethods":["BTC-LN"]`) || !strings.Contains(string(body), `"amount":"12.34"`) || !strings.Contains(string(body), `"orderId":"ABC:key:lightning"`) {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		created++
+		w.Write([]byte(`{"id": "invoice-1", "status": "New"}`))
+	})
+	mux.HandleFunc("GET /api/v1/stores/store-1/invoices/invoice-1", func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+		w.Write([]byte(`{"id": "invoice-1", "status": "` + status + `"}`))
+	})
+	mux.HandleFunc("GET /api/v1/stores/store-1/invoices/invoice-1/payment-methods", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`[{"paymentMethodId": "BTC-LN", "currency": "BTC", "destination": "lnbc1test", "amount": "0.00012"}]`))
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	repo := &testRepo{sumCents: 1234}
+	ln := BTCPayLightning{
+		Invoices:  NewMemoryInvoiceStore(time.Hour),
+		Store:     btcpay.Store{Host: srv.URL, ID: "store-1", UserAPIKey: "key", WebhookSecret: "secret"},
+		Purchases: repo,
+	}
+	l := lang.Lang{Printer: message.NewPrinter(language.English)}
+
+	for range 2 {
+		html, err := ln.PayHTML("ABC", "key", "/purchase", l)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, want := range []string{"lnbc1test", `href="lightning:lnbc1test"`, "data:image/png;base64,iVBOR", "0.00012 BTC", `payment-key=key\u0026purchase-id=ABC`} {
+			if !strings.Contains(string(html), want) {
+				t.Fatalf("%s not found in %s", want, html)
+			}
+		}
+	}
+	if created != 1 {
+		t.Fatalf("got %d created invoices, want 1", created)
+	}
+
+	handler := ln.Handler()
+	if w := serve(handler, http.MethodGet, "/payment/btcpay-lightning/invoice-status?purchase-id=ABC&payment-key=key", ""); strings.TrimSpace(w.Body.String()) != `{"status":"pending"}` {
+		t.Fatalf("got %s", w.Body.String())
+	}
+
+	lock.Lock()
+	status = btcpay.InvoiceSettled
+	lock.Unlock()
+	if w := serve(handler, http.MethodGet, "/payment/btcpay-lightning/invoice-status?purchase-id=ABC&payment-key=key", ""); strings.TrimSpace(w.Body.String()) != `{"status":"paid"}` {
+		t.Fatalf("got %s", w.Body.String())
+	}
+	if w := serve(handler, http.MethodGet, "/payment/btcpay-lightning/invoice-status?purchase-id=ABC&payment-key=other", ""); w.Code != http.StatusNotFound {
+		t.Fatalf("got status code %d", w.Code)
+	}
+	html, _ := ln.PayHTML("ABC", "key", "/purchase", l)
+	if !strings.Contains(string(html), "payment has been received") {
+		t.Fatalf("got %s", html)
+	}
+
+	// the webhook reports the Lightning method name
+	event := `{"type": "InvoiceSettled", "storeId": "store-1", "invoiceId": "invoice-1", "metadata": {"orderId": "ABC:key:lightning"}}`
+	mac := hmac.New(sha256.New, []byte("secret"))
+	mac.Write([]byte(event))
+	r := httptest.NewRequest(http.MethodPost, "/payment/btcpay-lightning/webhook", strings.NewReader(event))
+	r.Header.Set("BTCPay-Sig", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+	repo.check(t, "paid ABC:key BTCPayLightning")
+
+	// expired invoices are replaced
+	lock.Lock()
+	status = btcpay.InvoiceExpired
+	lock.Unlock()
+	ln.PayHTML("ABC", "key", "/purchase", l)
+	if created != 2 {
+		t.Fatalf("got %d created invoices, want 2", created)
+	}
+}
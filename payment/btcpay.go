@@ -3,11 +3,12 @@ package payment
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"html/template"
 	"log"
-	"math"
 	"net/http"
+	"net/url"
 	"strconv"
 	"strings"
 	"time"
@@ -32,15 +33,9 @@ type btcpayTmplData struct {
 	Status          bool
 }
 
-type createdInvoice struct {
-	ID   string
-	Time int64
-}
-
-var lastInvoice = make(map[string]createdInvoice) // key: purchase ID
-
 type BTCPay struct {
 	ExpirationMinutes int
+	Invoices          InvoiceStore // required, for example NewMemoryInvoiceStore(15 * time.Minute)
 	Store             btcpay.Store
 	Purchases         PurchaseRepo
 
@@ -52,12 +47,18 @@ type BTCPay struct {
 func (b BTCPay) Handler() http.Handler {
 	var mux = http.NewServeMux()
 	mux.Handle("POST /payment/btcpay/create-invoice", httputil.HandlerFunc(b.createInvoice))
+	mux.Handle("GET  /payment/btcpay/purchase-status", purchaseStatus(b.Purchases))
 	mux.Handle("GET  /payment/btcpay/redirect", httputil.HandlerFunc(b.redirect)) // after payment
 	mux.Handle("GET  /payment/btcpay/status", httputil.HandlerFunc(b.status))
 	mux.Handle("POST /payment/btcpay/webhook", httputil.HandlerFunc(b.webhook))
 	return mux
 }
 
+// Currencies returns nil because BTCPay Server supports all fiat currencies of its rate provider.
+func (BTCPay) Currencies() []string {
+	return nil
+}
+
 func (BTCPay) ID() string {
 	return "btcpay"
 }
@@ -98,19 +99,27 @@ func (b BTCPay) createInvoice(w http.ResponseWriter, r *http.Request) http.Handl
 	paymentKey := r.PostFormValue("payment-key")
 	redirectURL := r.PostFormValue("redirect-url")
 
-	// redirect to existing invoice if it is younger than 15 minutes
-	if last, ok := lastInvoice[purchaseID+":"+paymentKey]; ok && time.Now().Unix()-last.Time < 15*60 {
-		return http.RedirectHandler(b.Store.InvoiceCheckoutLink(last.ID, strings.HasSuffix(r.Host, ".onion") || strings.Contains(r.Host, ".onion:")), http.StatusSeeOther)
+	if b.Invoices == nil {
+		return b.ErrCreateInvoice(errors.New("InvoiceStore is nil"))
 	}
 
-	sumCents, err := b.Purchases.PurchaseSumCents(purchaseID, paymentKey)
+	// redirect to existing invoice
+	invoiceID, ok, err := b.Invoices.Get(purchaseID + ":" + paymentKey)
+	if err != nil {
+		log.Printf("error getting btcpay invoice from store: %v", err) // not fatal, create a new invoice
+	}
+	if ok {
+		return http.RedirectHandler(b.Store.InvoiceCheckoutLink(invoiceID, strings.HasSuffix(r.Host, ".onion") || strings.Contains(r.Host, ".onion:")), http.StatusSeeOther)
+	}
+
+	sum, err := PurchaseSum(b.Purchases, purchaseID, paymentKey)
 	if err != nil {
 		return b.ErrCreateInvoice(err)
 	}
 
 	invoiceRequest := &btcpay.InvoiceRequest{
-		Amount:   float64(sumCents) / 100.0,
-		Currency: "EUR",
+		Amount:   sum.Float(),
+		Currency: sum.Currency,
 	}
 	invoiceRequest.ExpirationMinutes = max(30, min(1440, b.ExpirationMinutes))
 	invoiceRequest.DefaultLanguage = defaultLanguage
@@ -129,9 +138,8 @@ func (b BTCPay) createInvoice(w http.ResponseWriter, r *http.Request) http.Handl
 		SameSite: http.SameSiteStrictMode,
 	})
 
-	lastInvoice[purchaseID+":"+paymentKey] = createdInvoice{
-		ID:   invoice.ID,
-		Time: time.Now().Unix(),
+	if err := b.Invoices.Set(purchaseID+":"+paymentKey, invoice.ID); err != nil {
+		log.Printf("error storing btcpay invoice: %v", err)
 	}
 
 	return http.RedirectHandler(b.Store.InvoiceCheckoutLink(invoice.ID, strings.HasSuffix(r.Host, ".onion") || strings.Contains(r.Host, ".onion:")), http.StatusSeeOther)
@@ -168,7 +176,12 @@ func (b BTCPay) webhook(w http.ResponseWriter, r *http.Request) http.Handler {
 	if err != nil {
 		return b.ErrWebhook(fmt.Errorf("getting event: %w", err))
 	}
-	purchaseID, paymentKey, _ := strings.Cut(event.InvoiceMetadata.OrderID, ":")
+	reference, lightning := strings.CutSuffix(event.InvoiceMetadata.OrderID, btcpayLightningSuffix)
+	purchaseID, paymentKey, _ := strings.Cut(reference, ":")
+	methodName := "BTCPay"
+	if lightning {
+		methodName = "BTCPayLightning"
+	}
 
 	switch event.Type {
 	case btcpay.EventInvoiceProcessing:
@@ -177,14 +190,18 @@ func (b BTCPay) webhook(w http.ResponseWriter, r *http.Request) http.Handler {
 		}
 		return nil
 	case btcpay.EventInvoiceSettled:
-		if err := b.Purchases.SetPurchasePaid(purchaseID, paymentKey, "BTCPay"); err != nil {
+		if err := b.Purchases.SetPurchasePaid(purchaseID, paymentKey, methodName); err != nil {
 			return b.ErrWebhook(fmt.Errorf("setting purchase %s paid: %w", purchaseID, err))
 		}
 		return nil
 	case btcpay.EventInvoicePaymentSettled:
+		sum, err := PurchaseSum(b.Purchases, purchaseID, paymentKey)
+		if err != nil {
+			return b.ErrWebhook(fmt.Errorf("getting currency of purchase %s: %w", purchaseID, err))
+		}
 		amountCrypto, _ := strconv.ParseFloat(event.Payment.Value, 64)
-		amountCents := int(math.Round(amountCrypto * event.Rate * 100.0))
-		if err := b.Purchases.PaymentSettled(purchaseID, paymentKey, "BTCPay", event.Payment.ID, amountCents, event.AfterExpiration); err != nil {
+		amountCents := FromFloat(amountCrypto*event.Rate, sum.Currency).Minor // event.Rate is in the invoice currency
+		if err := b.Purchases.PaymentSettled(purchaseID, paymentKey, methodName, event.Payment.ID, amountCents, event.AfterExpiration); err != nil {
 			return b.ErrWebhook(fmt.Errorf("setting purchase %s payment %s of %d: %w", purchaseID, event.Payment.ID, amountCents, err))
 		}
 		return nil
@@ -203,3 +220,99 @@ func absHost(r *http.Request) string {
 	}
 	return fmt.Sprintf("%s://%s", proto, r.Host)
 }
+
+// btcpayRequest performs a BTCPay Server Greenfield API request. The path is relative to "/api/v1/stores/{storeId}/".
+func (b BTCPay) btcpayRequest(method, path string, body, result any) error {
+	var header = http.Header{}
+	header.Set("Authorization", "token "+b.Store.UserAPIKey)
+	return doJSON(method, fmt.Sprintf("%s/api/v1/stores/%s/%s", b.Store.Host, url.PathEscape(b.Store.ID), path), header, body, result)
+}
+
+type btcpayRefundRequest struct {
+	Name           string `json:"name"`
+	Description    string `json:"description"`
+	PaymentMethod  string `json:"paymentMethod"`
+	RefundVariant  string `json:"refundVariant"`
+	CustomAmount   string `json:"customAmount"`
+	CustomCurrency string `json:"customCurrency"`
+}
+
+type btcpayPullPayment struct {
+	ID       string `json:"id"`
+	ViewLink string `json:"viewLink"`
+}
+
+// Refund creates a BTCPay pull payment. The payment ID is the ID of the invoice payment. The customer must claim the refund at Refund.ClaimURL.
+//
+// If cents is zero, the value of the payment at the time of payment is refunded.
+func (b BTCPay) Refund(purchaseID, paymentKey, paymentID string, cents int) (Refund, error) {
+	if cents < 0 {
+		return Refund{}, fmt.Errorf("invalid refund amount: %d", cents)
+	}
+
+	sum, err := PurchaseSum(b.Purchases, purchaseID, paymentKey)
+	if err != nil {
+		return Refund{}, fmt.Errorf("getting currency of purchase: %w", err)
+	}
+
+	// find invoice and payment method of the payment, including Lightning invoices of BTCPayLightning
+	reference := purchaseID + ":" + paymentKey
+	query := url.Values{"orderId": []string{reference, reference + btcpayLightningSuffix}}
+	var invoices []btcpay.Invoice
+	if err := b.btcpayRequest(http.MethodGet, "invoices?"+query.Encode(), nil, &invoices); err != nil {
+		return Refund{}, fmt.Errorf("getting invoices: %w", err)
+	}
+	var invoiceID, methodName, paymentMethodID string
+	var paymentCents int
+	for _, invoice := range invoices {
+		methods, err := b.Store.GetInvoicePaymentMethods(invoice.ID)
+		if err != nil {
+			return Refund{}, fmt.Errorf("getting payment methods of invoice %s: %w", invoice.ID, err)
+		}
+		for _, method := range methods {
+			for _, payment := range method.Payments {
+				if payment.ID == paymentID {
+					invoiceID = invoice.ID
+					methodName = "BTCPay" // same as in the webhook
+					if strings.HasSuffix(invoice.OrderID, btcpayLightningSuffix) {
+						methodName = "BTCPayLightning"
+					}
+					paymentMethodID = method.PaymentMethodID
+					amountCrypto, _ := strconv.ParseFloat(payment.Value, 64)
+					rate, _ := strconv.ParseFloat(method.Rate, 64)
+					paymentCents = FromFloat(amountCrypto*rate, sum.Currency).Minor
+				}
+			}
+		}
+	}
+	if invoiceID == "" {
+		return Refund{}, fmt.Errorf("payment %s not found", paymentID)
+	}
+	if cents == 0 {
+		cents = paymentCents
+	}
+
+	var pullPayment btcpayPullPayment
+	if err := b.btcpayRequest(http.MethodPost, "invoices/"+url.PathEscape(invoiceID)+"/refund", btcpayRefundRequest{
+		Name:           "Refund " + purchaseID,
+		Description:    "Refund of payment " + paymentID,
+		PaymentMethod:  paymentMethodID,
+		RefundVariant:  "Custom",
+		CustomAmount:   Amount{cents, sum.Currency}.Decimal(),
+		CustomCurrency: sum.Currency,
+	}, &pullPayment); err != nil {
+		return Refund{}, fmt.Errorf("creating refund for invoice %s: %w", invoiceID, err)
+	}
+
+	refund := Refund{
+		ID:       pullPayment.ID,
+		Cents:    cents,
+		ClaimURL: pullPayment.ViewLink,
+	}
+	log.Printf("[%s] created btcpay refund: invoice: %s, payment: %s, pull payment: %s", purchaseID+":"+paymentKey, invoiceID, paymentID, refund.ID)
+
+	if err := b.Purchases.PaymentRefunded(purchaseID, paymentKey, methodName, paymentID, refund.ID, refund.Cents); err != nil {
+		return refund, err
+	}
+	return refund, nil
+}
@@ -20,18 +20,36 @@ type cashForeignTmplData struct {
 	PurchaseID      string
 }
 
+// CashForeign is paid in cash in a foreign currency, either by mail or in the store.
+//
+// If StaffAuth is set, staff confirm payments at "/payment/cash-foreign/staff?purchase-id=...&payment-key=...", which shows the amounts due and calculates the change in euros.
 type CashForeign struct {
-	AddressHTML string
-	Purchases   PurchaseRepo
-	History     *rates.History
+	AddressHTML   string
+	Purchases     PurchaseRepo
+	History       *rates.History
+	RoundingCents int                        // optional, rounding of the change in euros, like 5 for five cents
+	StaffAuth     func(r *http.Request) bool // optional, should check the staff login
+	StaffLang     lang.Lang                  // optional, language of the staff handler, default: English
 }
 
 func (cash CashForeign) Handler() http.Handler {
 	var mux = http.NewServeMux()
+	mux.Handle("GET /payment/cash-foreign/purchase-status", purchaseStatus(cash.Purchases))
 	mux.HandleFunc("GET /payment/cash-foreign/status", func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Add("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(cash.History.Synced)
 	})
+	if cash.StaffAuth != nil {
+		mux.Handle("/payment/cash-foreign/staff", cashStaff{
+			Auth:          cash.StaffAuth,
+			History:       cash.History,
+			Lang:          cash.StaffLang,
+			MethodName:    "CashForeign",
+			Path:          "/payment/cash-foreign/staff",
+			Purchases:     cash.Purchases,
+			RoundingCents: cash.RoundingCents,
+		}.Handler())
+	}
 	return mux
 }
 
@@ -49,7 +67,7 @@ func (cash CashForeign) PayHTML(purchaseID, paymentKey, redirectURL string, l la
 		log.Printf("error getting purchase creation date from database: %v", err)
 		return template.HTML("Error getting purchase information from database"), nil
 	}
-	eurocents, err := cash.Purchases.PurchaseSumCents(purchaseID, paymentKey)
+	eurocents, err := eurSum(cash.Purchases, purchaseID, paymentKey)
 	if err != nil {
 		log.Printf("error getting purchase sum from database: %v", err)
 		return template.HTML("Error getting purchase information from database"), nil
@@ -0,0 +1,77 @@
+package payment
+
+import (
+	"bytes"
+	"html/template"
+	"log"
+	"net/http"
+
+	"github.com/dys2p/eco/lang"
+)
+
+var cashPickupTmpl = template.Must(template.ParseFS(htmlfiles, "cash-pickup.html"))
+
+type cashPickupTmplData struct {
+	lang.Lang
+	AddressHTML template.HTML
+	Amount      float64
+	PurchaseID  string
+}
+
+// CashOnPickup is paid in cash when the customer picks up the purchase.
+//
+// Staff confirm the payment at "/payment/cash-on-pickup/staff?purchase-id=...&payment-key=...", which shows the amount due and calculates the change.
+type CashOnPickup struct {
+	AddressHTML   string
+	Purchases     PurchaseRepo
+	RoundingCents int                        // optional, like 5 if the total is rounded to five cents
+	StaffAuth     func(r *http.Request) bool // required for the staff handler, should check the staff login
+	StaffLang     lang.Lang                  // optional, language of the staff handler, default: English
+}
+
+func (cash CashOnPickup) Handler() http.Handler {
+	var mux = http.NewServeMux()
+	mux.Handle("GET /payment/cash-on-pickup/purchase-status", purchaseStatus(cash.Purchases))
+	mux.Handle("/payment/cash-on-pickup/staff", cash.staff().Handler())
+	return mux
+}
+
+func (cash CashOnPickup) staff() cashStaff {
+	return cashStaff{
+		Auth:          cash.StaffAuth,
+		Lang:          cash.StaffLang,
+		MethodName:    "Cash",
+		Path:          "/payment/cash-on-pickup/staff",
+		Purchases:     cash.Purchases,
+		RoundingCents: cash.RoundingCents,
+	}
+}
+
+func (CashOnPickup) ID() string {
+	return "cash-on-pickup"
+}
+
+func (CashOnPickup) Name(l lang.Lang) string {
+	return l.Tr("Cash on Pickup")
+}
+
+func (cash CashOnPickup) PayHTML(purchaseID, paymentKey, redirectURL string, l lang.Lang) (template.HTML, error) {
+	eurocents, err := eurSum(cash.Purchases, purchaseID, paymentKey)
+	if err != nil {
+		log.Printf("error getting purchase sum from database: %v", err)
+		return template.HTML("Error getting purchase information from database"), nil
+	}
+
+	buf := &bytes.Buffer{}
+	err = cashPickupTmpl.Execute(buf, cashPickupTmplData{
+		Lang:        l,
+		AddressHTML: template.HTML(cash.AddressHTML),
+		Amount:      float64(RoundCash(eurocents, cash.RoundingCents)) / 100.0,
+		PurchaseID:  purchaseID,
+	})
+	return template.HTML(buf.String()), err
+}
+
+func (CashOnPickup) VerifiesAdult() bool {
+	return false
+}
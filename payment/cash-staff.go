@@ -0,0 +1,269 @@
+package payment
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"html/template"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/dys2p/eco/httputil"
+	"github.com/dys2p/eco/id"
+	"github.com/dys2p/eco/lang"
+	"github.com/dys2p/eco/payment/rates"
+)
+
+var cashStaffTmpl = template.Must(template.ParseFS(htmlfiles, "cash-staff.html"))
+
+// RoundCash rounds cents to the nearest multiple of step, like 5 for the cash rounding in Finland, Ireland, the Netherlands or Switzerland. Halves are rounded up. If step is less than two, cents is returned unchanged.
+func RoundCash(cents, step int) int {
+	if step < 2 {
+		return cents
+	}
+	return int(math.Floor(float64(cents)/float64(step)+0.5)) * step
+}
+
+type cashStaffCurrency struct {
+	Currency string
+	Due      string // formatted, without currency
+}
+
+type cashStaffTmplData struct {
+	lang.Lang
+	Path       string
+	CSRFToken  string
+	PurchaseID string
+	PaymentKey string
+	PaymentID  string // random, so repeated confirmations of the same form are recognized as duplicates
+	Currencies []cashStaffCurrency
+	Currency   string
+	Tendered   string
+	Due        string
+	Rounding   string // difference between the amount due and the purchase sum, empty if there is none
+	Change     string // in euros
+	Confirmed  bool
+	Err        string
+}
+
+// cashStaffCSRFCookie contains a random token which the confirmation form must repeat, so other sites can't submit the form on behalf of logged-in staff.
+const cashStaffCSRFCookie = "payment-cash-staff-csrf"
+
+// cashStaff is the staff-facing handler which is shared by CashOnPickup and CashForeign.
+type cashStaff struct {
+	Auth          func(r *http.Request) bool
+	History       *rates.History // optional, enables foreign currencies
+	Lang          lang.Lang      // optional, default: English
+	MethodName    string
+	Path          string
+	Purchases     PurchaseRepo
+	RoundingCents int
+}
+
+// a cashQuote is the amount due in a currency
+type cashQuote struct {
+	dueCents int     // euro cents, rounded
+	sumCents int     // euro cents, the purchase sum, which is settled
+	price    float64 // in the quoted currency
+	display  string  // price, formatted without currency
+}
+
+// quotes returns the amounts due in euros and, if History is set, in foreign currencies.
+func (staff cashStaff) quotes(purchaseID, paymentKey string) ([]string, map[string]cashQuote, error) {
+	sumCents, err := eurSum(staff.Purchases, purchaseID, paymentKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("getting purchase sum: %w", err)
+	}
+	dueCents := RoundCash(sumCents, staff.RoundingCents)
+	currencies := []string{"EUR"}
+	quotes := map[string]cashQuote{
+		"EUR": {dueCents, sumCents, float64(dueCents) / 100.0, fmt.Sprintf("%.2f", float64(dueCents)/100.0)},
+	}
+	if staff.History != nil {
+		date, err := staff.Purchases.PurchaseCreationDate(purchaseID, paymentKey)
+		if err != nil {
+			return nil, nil, fmt.Errorf("getting purchase creation date: %w", err)
+		}
+		options, err := staff.History.Options(date, float64(sumCents)/100.0)
+		if err != nil {
+			return nil, nil, fmt.Errorf("getting currency options: %w", err)
+		}
+		for _, option := range options {
+			currencies = append(currencies, option.Currency)
+			price, err := strconv.ParseFloat(option.Amount, 64) // rounded up to the smallest accepted amount
+			if err != nil {
+				return nil, nil, fmt.Errorf("parsing %s amount: %w", option.Currency, err)
+			}
+			quotes[option.Currency] = cashQuote{sumCents, sumCents, price, option.Amount}
+		}
+	}
+	return currencies, quotes, nil
+}
+
+// change returns the change in euro cents. It returns an error if tendered is less than the amount due.
+func (staff cashStaff) change(quote cashQuote, tendered float64) (int, error) {
+	if tendered < quote.price-0.005 {
+		return 0, fmt.Errorf("tendered amount is less than the amount due")
+	}
+	changeCents := int(math.Round(float64(quote.dueCents)*tendered/quote.price)) - quote.dueCents
+	return max(0, RoundCash(changeCents, staff.RoundingCents)), nil
+}
+
+func (staff cashStaff) Handler() http.Handler {
+	staff.Lang = withPrinter(staff.Lang)
+	var mux = http.NewServeMux()
+	mux.Handle("GET  "+staff.Path, httputil.HandlerFunc(staff.get))
+	mux.Handle("POST "+staff.Path, httputil.HandlerFunc(staff.confirm))
+	return staff.authorize(mux)
+}
+
+func (staff cashStaff) authorize(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if staff.Auth == nil || !staff.Auth(r) {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (staff cashStaff) get(w http.ResponseWriter, r *http.Request) http.Handler {
+	var token string
+	if cookie, err := r.Cookie(cashStaffCSRFCookie); err == nil && cookie.Value != "" {
+		token = cookie.Value
+	} else {
+		token = id.New(32, id.AlphanumCaseSensitiveDigits)
+		http.SetCookie(w, &http.Cookie{
+			Name:     cashStaffCSRFCookie,
+			Value:    token,
+			Path:     staff.Path,
+			HttpOnly: true, // no javascript
+			SameSite: http.SameSiteStrictMode,
+		})
+	}
+
+	data := cashStaffTmplData{
+		Lang:       staff.Lang,
+		Path:       staff.Path,
+		CSRFToken:  token,
+		PurchaseID: r.URL.Query().Get("purchase-id"),
+		PaymentKey: r.URL.Query().Get("payment-key"),
+		PaymentID:  id.New(12, id.AlphanumCaseSensitiveDigits),
+		Currency:   r.URL.Query().Get("currency"),
+		Tendered:   r.URL.Query().Get("tendered"),
+		Confirmed:  r.URL.Query().Get("confirmed") == "true",
+	}
+	if data.Currency == "" {
+		data.Currency = "EUR"
+	}
+
+	currencies, quotes, err := staff.quotes(data.PurchaseID, data.PaymentKey)
+	if err != nil {
+		log.Printf("error getting cash quotes: %v", err)
+		return staff.render(data, staff.Lang.Tr("Error getting purchase information"))
+	}
+	for _, currency := range currencies {
+		data.Currencies = append(data.Currencies, cashStaffCurrency{currency, quotes[currency].display})
+	}
+	quote, ok := quotes[data.Currency]
+	if !ok {
+		return staff.render(data, staff.Lang.Tr("Unknown currency"))
+	}
+	data.Due = quote.display + " " + data.Currency
+	if diff := quote.dueCents - quote.sumCents; data.Currency == "EUR" && diff != 0 {
+		data.Rounding = Amount{diff, "EUR"}.Decimal() + " EUR"
+		if diff > 0 {
+			data.Rounding = "+" + data.Rounding
+		}
+	}
+
+	if data.Tendered != "" {
+		tendered, err := parseDecimal(data.Tendered)
+		if err != nil {
+			return staff.render(data, staff.Lang.Tr("Invalid tendered amount"))
+		}
+		changeCents, err := staff.change(quote, tendered)
+		if err != nil {
+			return staff.render(data, staff.Lang.Tr("The tendered amount is less than the amount due"))
+		}
+		data.Change = formatCents(changeCents) + " EUR"
+	}
+	return staff.render(data, "")
+}
+
+func (staff cashStaff) confirm(w http.ResponseWriter, r *http.Request) http.Handler {
+	var (
+		purchaseID = r.PostFormValue("purchase-id")
+		paymentKey = r.PostFormValue("payment-key")
+		paymentID  = r.PostFormValue("payment-id")
+		currency   = r.PostFormValue("currency")
+		token      = r.PostFormValue("csrf-token")
+	)
+	if cookie, err := r.Cookie(cashStaffCSRFCookie); err != nil || token == "" || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(token)) != 1 {
+		return staffError(staff.Lang.Tr("The form has expired. Please reload the page and try again."), http.StatusForbidden)
+	}
+	if paymentID == "" {
+		return staffError(staff.Lang.Tr("Missing payment ID"), http.StatusBadRequest)
+	}
+	tendered, err := parseDecimal(r.PostFormValue("tendered"))
+	if err != nil {
+		return staffError(staff.Lang.Tr("Invalid tendered amount"), http.StatusBadRequest)
+	}
+	_, quotes, err := staff.quotes(purchaseID, paymentKey)
+	if err != nil {
+		log.Printf("error getting cash quotes: %v", err)
+		return staffError(staff.Lang.Tr("Error getting purchase information"), http.StatusInternalServerError)
+	}
+	quote, ok := quotes[currency]
+	if !ok {
+		return staffError(staff.Lang.Tr("Unknown currency"), http.StatusBadRequest)
+	}
+	if _, err := staff.change(quote, tendered); err != nil {
+		return staffError(staff.Lang.Tr("The tendered amount is less than the amount due"), http.StatusBadRequest)
+	}
+
+	log.Printf("[%s] confirmed cash payment: %s, tendered: %.2f %s", purchaseID+":"+paymentKey, paymentID, tendered, currency)
+
+	// settle the purchase sum, not the rounded amount due, so the rounding difference does not show up as an under- or overpayment
+	if err := staff.Purchases.PaymentSettled(purchaseID, paymentKey, staff.MethodName, paymentID, quote.sumCents, false); err != nil {
+		log.Printf("error reporting cash payment: %v", err)
+		return staffError(staff.Lang.Tr("Error saving payment"), http.StatusInternalServerError)
+	}
+	if err := staff.Purchases.SetPurchasePaid(purchaseID, paymentKey, staff.MethodName); err != nil {
+		log.Printf("error setting purchase paid: %v", err)
+		return staffError(staff.Lang.Tr("Error saving payment"), http.StatusInternalServerError)
+	}
+
+	query := url.Values{}
+	query.Set("purchase-id", purchaseID)
+	query.Set("payment-key", paymentKey)
+	query.Set("confirmed", "true")
+	return http.RedirectHandler(staff.Path+"?"+query.Encode(), http.StatusSeeOther)
+}
+
+func (staff cashStaff) render(data cashStaffTmplData, errMsg string) http.Handler {
+	data.Err = errMsg
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := cashStaffTmpl.Execute(w, data); err != nil {
+			log.Printf("error executing cash staff template: %v", err)
+		}
+	})
+}
+
+func staffError(msg string, code int) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, msg, code)
+	})
+}
+
+// parseDecimal parses a non-negative decimal number with a dot or comma separator.
+func parseDecimal(s string) (float64, error) {
+	f, err := strconv.ParseFloat(strings.Replace(strings.TrimSpace(s), ",", ".", 1), 64)
+	if err != nil || f < 0 || math.IsInf(f, 0) || math.IsNaN(f) {
+		return 0, fmt.Errorf("invalid decimal number: %s", s)
+	}
+	return f, nil
+}
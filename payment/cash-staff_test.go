@@ -0,0 +1,138 @@
+package payment
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/dys2p/eco/payment/rates"
+)
+
+func TestRoundCash(t *testing.T) {
+	tests := []struct {
+		cents int
+		step  int
+		want  int
+	}{
+		{1232, 5, 1230},
+		{1233, 5, 1235},
+		{1237, 5, 1235},
+		{1238, 5, 1240},
+		{1232, 0, 1232},
+		{1232, 1, 1232},
+		{-3, 5, -5},
+	}
+	for _, test := range tests {
+		if got := RoundCash(test.cents, test.step); got != test.want {
+			t.Fatalf("RoundCash(%d, %d): got %d, want %d", test.cents, test.step, got, test.want)
+		}
+	}
+}
+
+func serve(handler http.Handler, method, target, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	if body != "" {
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	return w
+}
+
+// confirmStaff posts the staff form with a CSRF token
+func confirmStaff(handler http.Handler, target, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, target, strings.NewReader(body+"&csrf-token=token-1"))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.AddCookie(&http.Cookie{Name: cashStaffCSRFCookie, Value: "token-1"})
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	return w
+}
+
+func TestCashOnPickup(t *testing.T) {
+	repo := &testRepo{sumCents: 1232}
+	var authorized bool
+	handler := CashOnPickup{
+		Purchases:     repo,
+		RoundingCents: 5,
+		StaffAuth: func(r *http.Request) bool {
+			return authorized
+		},
+	}.Handler()
+
+	if w := serve(handler, http.MethodGet, "/payment/cash-on-pickup/staff?purchase-id=ABC&payment-key=key", ""); w.Code != http.StatusForbidden {
+		t.Fatalf("got status %d, want forbidden", w.Code)
+	}
+	authorized = true
+
+	w := serve(handler, http.MethodGet, "/payment/cash-on-pickup/staff?purchase-id=ABC&payment-key=key&tendered=20", "")
+	if body := w.Body.String(); !strings.Contains(body, "12.30 EUR") || !strings.Contains(body, "Change: <strong>7.70 EUR</strong>") || !strings.Contains(body, "Cash rounding: -0.02 EUR") {
+		t.Fatalf("amount due, change or rounding not found in %s", body)
+	}
+	var token string
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.Name == cashStaffCSRFCookie {
+			token = cookie.Value
+		}
+	}
+	if token == "" || !strings.Contains(w.Body.String(), `name="csrf-token" value="`+token+`"`) {
+		t.Fatalf("CSRF token not found in %s", w.Body)
+	}
+
+	w = serve(handler, http.MethodGet, "/payment/cash-on-pickup/staff?purchase-id=ABC&payment-key=key&tendered=10", "")
+	if body := w.Body.String(); !strings.Contains(body, "less than the amount due") || strings.Contains(body, "Confirm payment") {
+		t.Fatalf("error not found in %s", body)
+	}
+
+	if w := confirmStaff(handler, "/payment/cash-on-pickup/staff", "purchase-id=ABC&payment-key=key&payment-id=P1&currency=EUR&tendered=12"); w.Code != http.StatusBadRequest {
+		t.Fatalf("got status %d, want bad request", w.Code)
+	}
+	repo.check(t)
+
+	// cross-site form without token
+	if w := serve(handler, http.MethodPost, "/payment/cash-on-pickup/staff", "purchase-id=ABC&payment-key=key&payment-id=P1&currency=EUR&tendered=20"); w.Code != http.StatusForbidden {
+		t.Fatalf("got status %d, want forbidden", w.Code)
+	}
+	repo.check(t)
+
+	// the purchase sum is settled, not the rounded amount due
+	w = confirmStaff(handler, "/payment/cash-on-pickup/staff", "purchase-id=ABC&payment-key=key&payment-id=P1&currency=EUR&tendered=20")
+	if w.Code != http.StatusSeeOther {
+		t.Fatalf("got status %d, want redirect", w.Code)
+	}
+	repo.check(t, "settled ABC:key Cash P1 1232 false", "paid ABC:key Cash")
+}
+
+func TestCashForeignStaff(t *testing.T) {
+	db, err := rates.OpenDB(filepath.Join(t.TempDir(), "rates.sqlite3"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Insert("2024-01-01", map[string]float64{"USD": 1.1}); err != nil {
+		t.Fatal(err)
+	}
+
+	repo := &testRepo{creationDate: "2024-01-02", sumCents: 1000}
+	handler := CashForeign{
+		Purchases:     repo,
+		History:       &rates.History{Database: db},
+		RoundingCents: 5,
+		StaffAuth: func(r *http.Request) bool {
+			return true
+		},
+	}.Handler()
+
+	// 20 USD for 11 USD due: 9 USD change are 8.18 EUR, rounded to 8.20 EUR
+	w := serve(handler, http.MethodGet, "/payment/cash-foreign/staff?purchase-id=ABC&payment-key=key&currency=USD&tendered=20", "")
+	if body := w.Body.String(); !strings.Contains(body, "11.00 USD") || !strings.Contains(body, "Change: <strong>8.20 EUR</strong>") {
+		t.Fatalf("amount due or change not found in %s", body)
+	}
+
+	w = confirmStaff(handler, "/payment/cash-foreign/staff", "purchase-id=ABC&payment-key=key&payment-id=P2&currency=USD&tendered=20")
+	if w.Code != http.StatusSeeOther {
+		t.Fatalf("got status %d, want redirect", w.Code)
+	}
+	repo.check(t, "settled ABC:key CashForeign P2 1000 false", "paid ABC:key CashForeign")
+}
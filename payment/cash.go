@@ -18,10 +18,13 @@ type cashTmplData struct {
 
 type Cash struct {
 	AddressHTML string
+	Purchases   PurchaseRepo // optional, for the purchase-status endpoint
 }
 
-func (Cash) Handler() http.Handler {
-	return http.NewServeMux()
+func (cash Cash) Handler() http.Handler {
+	var mux = http.NewServeMux()
+	mux.Handle("GET /payment/cash/purchase-status", purchaseStatus(cash.Purchases))
+	return mux
 }
 
 func (Cash) ID() string {
@@ -0,0 +1,79 @@
+package payment
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	neturl "net/url"
+	"strings"
+	"time"
+)
+
+var client = &http.Client{
+	Timeout: 10 * time.Second,
+}
+
+// A statusError is returned by doJSON if the response status code is not 2xx.
+type statusError struct {
+	Method string
+	URL    string
+	Status string
+	Body   []byte
+}
+
+func (err *statusError) Error() string {
+	return fmt.Sprintf("%s %s: %s: %s", err.Method, err.URL, err.Status, err.Body)
+}
+
+// doJSON sends a request with an optional body and unmarshals the JSON response into result, which can be nil.
+// The body is form-encoded if it is url.Values, else it is encoded as JSON.
+// It returns a *statusError if the response status code is not 2xx.
+func doJSON(method, url string, header http.Header, body, result any) error {
+	var reqBody io.Reader
+	var contentType string
+	switch body := body.(type) {
+	case nil:
+	case neturl.Values:
+		reqBody = strings.NewReader(body.Encode())
+		contentType = "application/x-www-form-urlencoded"
+	default:
+		payload, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reqBody = bytes.NewReader(payload)
+		contentType = "application/json"
+	}
+
+	req, err := http.NewRequest(method, url, reqBody)
+	if err != nil {
+		return err
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	req.Header.Set("Accept", "application/json")
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return &statusError{method, url, resp.Status, respBody}
+	}
+	if result == nil {
+		return nil
+	}
+	return json.Unmarshal(respBody, result)
+}
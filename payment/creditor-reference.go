@@ -0,0 +1,52 @@
+package payment
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/dys2p/eco/iban"
+)
+
+// CreditorReference returns the ISO 11649 creditor reference ("RF reference") of a purchase ID, like "RF18ABCDEF" for "ABCDEF".
+// The check digits let banks reject mistyped references.
+//
+// The purchase ID must consist of 1 to 21 ASCII letters and digits. As creditor references are case-insensitive, purchase IDs should be case-insensitive too, see id.AlphanumCaseInsensitiveDigits.
+func CreditorReference(purchaseID string) (string, error) {
+	if purchaseID == "" || len(purchaseID) > 21 || !isAlnum(purchaseID) {
+		return "", fmt.Errorf("purchase ID is not suitable for a creditor reference: %s", purchaseID)
+	}
+	purchaseID = strings.ToUpper(purchaseID)
+	return fmt.Sprintf("RF%02d%s", 98-iban.Mod97(purchaseID+"RF00"), purchaseID), nil
+}
+
+// ParseCreditorReference verifies a creditor reference and returns the purchase ID it contains, in upper case.
+// Whitespace is ignored and lower case letters are accepted.
+func ParseCreditorReference(ref string) (string, error) {
+	ref = strings.ToUpper(removeWhitespaces(ref))
+	if !validRF(ref) {
+		return "", errors.New("invalid creditor reference")
+	}
+	return ref[4:], nil
+}
+
+// FormatCreditorReference returns ref in groups of four characters, like "RF18 5390 0754 7034", which is the print format of ISO 11649.
+func FormatCreditorReference(ref string) string {
+	ref = strings.ToUpper(removeWhitespaces(ref))
+	var result strings.Builder
+	for i, r := range ref {
+		if i > 0 && i%4 == 0 {
+			result.WriteByte(' ')
+		}
+		result.WriteRune(r)
+	}
+	return result.String()
+}
+
+// validRF checks the format and the checksum of an ISO 11649 creditor reference.
+func validRF(ref string) bool {
+	if len(ref) < 5 || len(ref) > 25 || !strings.HasPrefix(ref, "RF") || !isAlnum(ref) {
+		return false
+	}
+	return iban.Mod97(ref[4:]+ref[:4]) == 1
+}
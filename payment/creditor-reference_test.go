@@ -0,0 +1,42 @@
+package payment
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCreditorReference(t *testing.T) {
+	tests := []struct {
+		purchaseID string
+		want       string
+	}{
+		{"539007547034", "RF18539007547034"}, // example from ISO 11649
+		{"abcdef", "RF02ABCDEF"},
+		{"A", "RF25A"},
+	}
+	for _, test := range tests {
+		got, err := CreditorReference(test.purchaseID)
+		if err != nil || got != test.want {
+			t.Fatalf("%s: got %s %v, want %s", test.purchaseID, got, err, test.want)
+		}
+		purchaseID, err := ParseCreditorReference(FormatCreditorReference(got))
+		if err != nil || purchaseID != strings.ToUpper(test.purchaseID) {
+			t.Fatalf("%s: parsed %s %v", got, purchaseID, err)
+		}
+	}
+
+	for _, invalid := range []string{"", "ABC-DEF", "ABCDEFGHJKLMNPQRSTUVWX"} {
+		if _, err := CreditorReference(invalid); err == nil {
+			t.Fatalf("%s: got nil error", invalid)
+		}
+	}
+	for _, invalid := range []string{"", "RF18", "RF19539007547034", "RF18539007547043", "XX18539007547034"} {
+		if _, err := ParseCreditorReference(invalid); err == nil {
+			t.Fatalf("%s: got nil error", invalid)
+		}
+	}
+
+	if got := FormatCreditorReference("rf18539007547034"); got != "RF18 5390 0754 7034" {
+		t.Fatalf("got %s", got)
+	}
+}
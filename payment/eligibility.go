@@ -0,0 +1,180 @@
+package payment
+
+import (
+	"html/template"
+	"slices"
+
+	"github.com/dys2p/eco"
+	"github.com/dys2p/eco/countries"
+	"github.com/dys2p/eco/lang"
+	"golang.org/x/text/language"
+	"golang.org/x/text/message"
+)
+
+// A Rule restricts the availability of a payment method. The zero value allows everything.
+type Rule[P any] struct {
+	MinCents  int                                  // optional, in the minor unit of the purchase currency (see Eligibility.Currency)
+	MaxCents  int                                  // optional, in the minor unit of the purchase currency (see Eligibility.Currency)
+	Countries []countries.Country                  // optional, allowed countries
+	Fee       Fee                                  // optional, surcharge or discount
+	Forbid    func(purchase P, l lang.Lang) string // optional, returns a translated reason if the method must not be used for the purchase
+}
+
+// Eligibility decides which payment methods are available for a purchase of type P.
+//
+// Unlike Get, which falls back to the first method, Eligibility never returns a method which the rules forbid.
+type Eligibility[P any] struct {
+	Methods       []Method
+	Rules         map[string]Rule[P]      // key: method ID
+	AdultRequired func(purchase P) bool   // optional, returns true if the purchase contains goods which require an age verification, then only methods which verify the age are available
+	Currency      func(purchase P) string // optional, returns the ISO 4217 currency of the purchase, then only methods which support it are available
+}
+
+// A MethodOption is a payment method with its translated name and availability, for checkout pages.
+type MethodOption struct {
+	Method
+	Name       string
+	Selected   bool
+	Reasons    []string // translated reasons why the method is unavailable, empty if it is available
+	Currency   string   // ISO 4217 currency of FeeCents and TotalCents, from Eligibility.Currency, default: EUR
+	FeeCents   int      // surcharge or, if negative, discount
+	TotalCents int      // adjusted gross total
+}
+
+// FeeHTML returns the surcharge or discount, like "+1,50&nbsp;€" or "+1.50&nbsp;CHF", or an empty string.
+func (opt MethodOption) FeeHTML() template.HTML {
+	if opt.FeeCents == 0 {
+		return ""
+	}
+	if opt.Currency == "" || opt.Currency == "EUR" {
+		return eco.FmtEuroPlusMinusHTML(opt.FeeCents)
+	}
+	sign := "+"
+	if opt.FeeCents < 0 {
+		sign = "−"
+	}
+	return template.HTML(sign + Amount{max(opt.FeeCents, -opt.FeeCents), opt.Currency}.Decimal() + "&nbsp;" + template.HTMLEscapeString(opt.Currency))
+}
+
+func (opt MethodOption) Available() bool {
+	return len(opt.Reasons) == 0
+}
+
+// Options returns the available and the unavailable payment methods, in the order of Methods.
+func (e Eligibility[P]) Options(selectedID string, sumCents int, country countries.Country, purchase P, l lang.Lang) (available, unavailable []MethodOption) {
+	l = withPrinter(l)
+	var currency string
+	if e.Currency != nil {
+		currency = e.Currency(purchase)
+	}
+	for _, method := range e.Methods {
+		fee := e.Fee(method.ID())
+		option := MethodOption{
+			Method:     method,
+			Name:       method.Name(l),
+			Selected:   method.ID() == selectedID,
+			Reasons:    e.reasons(method, sumCents, country, purchase, l),
+			Currency:   currency,
+			FeeCents:   fee.Cents(sumCents),
+			TotalCents: fee.Total(sumCents),
+		}
+		if option.Available() {
+			available = append(available, option)
+		} else {
+			option.Selected = false
+			unavailable = append(unavailable, option)
+		}
+	}
+	return
+}
+
+// Fee returns the surcharge or discount of the method with the given ID. Use Fee.Total for the adjusted gross total and Fee.Net for its VAT.
+func (e Eligibility[P]) Fee(methodID string) Fee {
+	return e.Rules[methodID].Fee
+}
+
+// Eligible returns the method with the given ID if it is available. Use it when the checkout form is submitted.
+func (e Eligibility[P]) Eligible(methodID string, sumCents int, country countries.Country, purchase P) (Method, bool) {
+	for _, method := range e.Methods {
+		if method.ID() == methodID {
+			if len(e.reasons(method, sumCents, country, purchase, withPrinter(lang.Lang{}))) > 0 {
+				return nil, false
+			}
+			return method, true
+		}
+	}
+	return nil, false
+}
+
+func (e Eligibility[P]) reasons(method Method, sumCents int, country countries.Country, purchase P, l lang.Lang) []string {
+	var reasons []string
+	if e.AdultRequired != nil && !method.VerifiesAdult() && e.AdultRequired(purchase) {
+		reasons = append(reasons, adultReason(l))
+	}
+	var currency string
+	if e.Currency != nil {
+		currency = e.Currency(purchase)
+		if !Supports(method, currency) {
+			reasons = append(reasons, currencyReason(l, currency))
+		}
+	}
+	rule, ok := e.Rules[method.ID()]
+	if !ok {
+		return reasons
+	}
+	if rule.MinCents > 0 && sumCents < rule.MinCents {
+		reasons = append(reasons, minReason(l, rule.MinCents, currency))
+	}
+	if rule.MaxCents > 0 && sumCents > rule.MaxCents {
+		reasons = append(reasons, maxReason(l, rule.MaxCents, currency))
+	}
+	if len(rule.Countries) > 0 && !slices.Contains(rule.Countries, country) {
+		reasons = append(reasons, countryReason(l))
+	}
+	if rule.Forbid != nil {
+		if reason := rule.Forbid(purchase, l); reason != "" {
+			reasons = append(reasons, reason)
+		}
+	}
+	return reasons
+}
+
+// withPrinter sets an English printer if l has none
+func withPrinter(l lang.Lang) lang.Lang {
+	if l.Printer == nil {
+		l.Printer = message.NewPrinter(language.English)
+	}
+	return l
+}
+
+// The reasons are translated outside of the generic Eligibility, because gotext does not extract messages from generic code.
+
+func adultReason(l lang.Lang) string {
+	return l.Tr("This payment method is not available for goods which require an age verification.")
+}
+
+func countryReason(l lang.Lang) string {
+	return l.Tr("This payment method is not available in your country.")
+}
+
+func currencyReason(l lang.Lang, currency string) string {
+	return l.Tr("This payment method does not support %s.", currency)
+}
+
+func maxReason(l lang.Lang, cents int, currency string) string {
+	amount := fmtAmount(cents, currency)
+	return l.Tr("The maximum amount for this payment method is %s.", amount)
+}
+
+func minReason(l lang.Lang, cents int, currency string) string {
+	amount := fmtAmount(cents, currency)
+	return l.Tr("The minimum amount for this payment method is %s.", amount)
+}
+
+// fmtAmount formats EUR like eco.FmtEuro and other currencies like "12.34 CHF".
+func fmtAmount(cents int, currency string) string {
+	if currency == "" || currency == "EUR" {
+		return eco.FmtEuro(cents)
+	}
+	return Amount{cents, currency}.String()
+}
@@ -0,0 +1,101 @@
+package payment
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/dys2p/eco/countries"
+	"github.com/dys2p/eco/lang"
+)
+
+type testPurchase struct {
+	adult    bool
+	currency string
+	digital  bool
+}
+
+func TestEligibility(t *testing.T) {
+	eligibility := Eligibility[testPurchase]{
+		Methods: []Method{Cash{}, PayPal{}, SEPA{}},
+		Rules: map[string]Rule[testPurchase]{
+			"cash": {
+				MaxCents: 50000,
+				Forbid: func(purchase testPurchase, l lang.Lang) string {
+					if purchase.digital {
+						return l.Tr("Digital goods can't be paid in cash.")
+					}
+					return ""
+				},
+			},
+			"sepa": {
+				MinCents:  1000,
+				Countries: []countries.Country{countries.AT, countries.DE},
+			},
+		},
+		AdultRequired: func(purchase testPurchase) bool {
+			return purchase.adult
+		},
+		Currency: func(purchase testPurchase) string {
+			if purchase.currency == "" {
+				return "EUR"
+			}
+			return purchase.currency
+		},
+	}
+
+	ids := func(options []MethodOption) []string {
+		var ids []string
+		for _, option := range options {
+			ids = append(ids, option.ID())
+		}
+		return ids
+	}
+
+	tests := []struct {
+		sumCents        int
+		country         countries.Country
+		purchase        testPurchase
+		wantAvailable   []string
+		wantUnavailable []string
+	}{
+		{5000, countries.DE, testPurchase{}, []string{"cash", "paypal-checkout", "sepa"}, nil},
+		{500, countries.DE, testPurchase{}, []string{"cash", "paypal-checkout"}, []string{"sepa"}},
+		{5000, countries.FR, testPurchase{}, []string{"cash", "paypal-checkout"}, []string{"sepa"}},
+		{60000, countries.DE, testPurchase{}, []string{"paypal-checkout", "sepa"}, []string{"cash"}},
+		{5000, countries.DE, testPurchase{digital: true}, []string{"paypal-checkout", "sepa"}, []string{"cash"}},
+		{5000, countries.DE, testPurchase{adult: true}, []string{"paypal-checkout"}, []string{"cash", "sepa"}},
+		{5000, countries.DE, testPurchase{currency: "CHF"}, []string{"paypal-checkout"}, []string{"cash", "sepa"}},
+	}
+	for i, test := range tests {
+		available, unavailable := eligibility.Options("sepa", test.sumCents, test.country, test.purchase, lang.Lang{})
+		if !slices.Equal(ids(available), test.wantAvailable) || !slices.Equal(ids(unavailable), test.wantUnavailable) {
+			t.Fatalf("test %d: got %v %v, want %v %v", i, ids(available), ids(unavailable), test.wantAvailable, test.wantUnavailable)
+		}
+		for _, option := range unavailable {
+			if option.Selected || len(option.Reasons) == 0 {
+				t.Fatalf("test %d: got %+v", i, option)
+			}
+		}
+		for _, id := range test.wantAvailable {
+			if _, ok := eligibility.Eligible(id, test.sumCents, test.country, test.purchase); !ok {
+				t.Fatalf("test %d: %s is not eligible", i, id)
+			}
+		}
+		for _, id := range test.wantUnavailable {
+			if _, ok := eligibility.Eligible(id, test.sumCents, test.country, test.purchase); ok {
+				t.Fatalf("test %d: %s is eligible", i, id)
+			}
+		}
+	}
+
+	_, unavailable := eligibility.Options("", 500, countries.FR, testPurchase{}, lang.Lang{})
+	want := []string{"The minimum amount for this payment method is 10,00 €.", "This payment method is not available in your country."}
+	if !slices.Equal(unavailable[0].Reasons, want) {
+		t.Fatalf("got reasons %q, want %q", unavailable[0].Reasons, want)
+	}
+
+	_, unavailable = eligibility.Options("", 60000, countries.DE, testPurchase{currency: "CHF"}, lang.Lang{})
+	if reason := "The maximum amount for this payment method is 500.00 CHF."; !slices.Contains(unavailable[0].Reasons, reason) {
+		t.Fatalf("got reasons %q, want %q", unavailable[0].Reasons, reason)
+	}
+}
@@ -0,0 +1,216 @@
+package payment
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/dys2p/eco/iban"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+)
+
+// EPCQR is the payload of an EPC QR code ("BCD" code, EPC069-12), which banking apps use to prefill SEPA credit transfers.
+type EPCQR struct {
+	Version     string // "001" or "002", BIC is optional in version 002
+	BIC         string
+	Name        string // beneficiary name, max 70 characters
+	IBAN        string
+	Cents       int    // optional, 1 to 99999999999 euro cents
+	Purpose     string // optional, four-letter code like "GDSV"
+	Reference   string // optional, structured creditor reference (ISO 11649 RF), max 35 characters, must not be used together with Text
+	Text        string // optional, unstructured remittance information, max 140 characters, must not be used together with Reference
+	Information string // optional, beneficiary to originator information, max 70 characters
+}
+
+const epcMaxBytes = 331
+
+// EPC069-12 character sets, UTF-8 and ISO 8859
+var epcCharsets = map[string]encoding.Encoding{
+	"1": encoding.Nop,
+	"2": charmap.ISO8859_1,
+	"3": charmap.ISO8859_2,
+	"4": charmap.ISO8859_4,
+	"5": charmap.ISO8859_5,
+	"6": charmap.ISO8859_7,
+	"7": charmap.ISO8859_10,
+	"8": charmap.ISO8859_15,
+}
+
+// Validate checks the constraints of EPC069-12.
+func (q EPCQR) Validate() error {
+	switch q.Version {
+	case "001":
+		if q.BIC == "" {
+			return errors.New("BIC is required in version 001")
+		}
+	case "002":
+	default:
+		return fmt.Errorf("invalid version: %s", q.Version)
+	}
+	if q.BIC != "" {
+		if err := iban.ValidateBIC(q.BIC); err != nil {
+			return fmt.Errorf("invalid BIC %s: %w", q.BIC, err)
+		}
+	}
+	if q.Name == "" {
+		return errors.New("missing beneficiary name")
+	}
+	if utf8.RuneCountInString(q.Name) > 70 {
+		return errors.New("beneficiary name exceeds 70 characters")
+	}
+	if err := iban.Validate(q.IBAN); err != nil {
+		return fmt.Errorf("invalid IBAN %s: %w", q.IBAN, err)
+	}
+	if q.Cents < 0 || q.Cents > 99999999999 {
+		return fmt.Errorf("amount out of range: %d cents", q.Cents)
+	}
+	if q.Purpose != "" && (len(q.Purpose) != 4 || strings.ToUpper(q.Purpose) != q.Purpose || !isAlnum(q.Purpose)) {
+		return fmt.Errorf("invalid purpose code: %s", q.Purpose)
+	}
+	if q.Reference != "" && q.Text != "" {
+		return errors.New("reference and text must not be used together")
+	}
+	if q.Reference != "" && (len(q.Reference) > 35 || !validRF(q.Reference)) {
+		return fmt.Errorf("invalid creditor reference: %s", q.Reference)
+	}
+	if utf8.RuneCountInString(q.Text) > 140 {
+		return errors.New("remittance text exceeds 140 characters")
+	}
+	if utf8.RuneCountInString(q.Information) > 70 {
+		return errors.New("beneficiary to originator information exceeds 70 characters")
+	}
+	for _, field := range []string{q.BIC, q.Name, q.IBAN, q.Purpose, q.Reference, q.Text, q.Information} {
+		if strings.ContainsAny(field, "\r\n") {
+			return errors.New("fields must not contain line breaks")
+		}
+	}
+	return nil
+}
+
+// Encode validates q and returns the UTF-8 encoded payload.
+func (q EPCQR) Encode() (string, error) {
+	if err := q.Validate(); err != nil {
+		return "", err
+	}
+	var amount string
+	if q.Cents > 0 {
+		amount = "EUR" + formatCents(q.Cents)
+	}
+	payload := strings.Join([]string{
+		"BCD",
+		q.Version,
+		"1", // UTF-8
+		"SCT",
+		q.BIC,
+		q.Name,
+		q.IBAN,
+		amount,
+		q.Purpose,
+		q.Reference,
+		q.Text,
+		q.Information,
+	}, "\n")
+	payload = strings.TrimRight(payload, "\n") // trailing empty fields can be omitted
+	if len(payload) > epcMaxBytes {
+		return "", fmt.Errorf("payload exceeds %d bytes", epcMaxBytes)
+	}
+	return payload, nil
+}
+
+// DecodeEPCQR parses and validates an EPC QR code payload. It supports all character sets of EPC069-12.
+func DecodeEPCQR(payload string) (EPCQR, error) {
+	if len(payload) > epcMaxBytes {
+		return EPCQR{}, fmt.Errorf("payload exceeds %d bytes", epcMaxBytes)
+	}
+	payload = strings.ReplaceAll(payload, "\r\n", "\n")
+	payload = strings.TrimSuffix(payload, "\n") // some encoders terminate the last line
+	lines := strings.Split(payload, "\n")
+	if len(lines) < 7 {
+		return EPCQR{}, errors.New("payload is incomplete")
+	}
+	if len(lines) > 12 {
+		return EPCQR{}, errors.New("payload has too many lines")
+	}
+	for len(lines) < 12 {
+		lines = append(lines, "")
+	}
+	if lines[0] != "BCD" {
+		return EPCQR{}, errors.New("invalid service tag")
+	}
+	charset, ok := epcCharsets[lines[2]]
+	if !ok {
+		return EPCQR{}, fmt.Errorf("invalid character set: %s", lines[2])
+	}
+	if lines[2] == "1" && !utf8.ValidString(payload) {
+		return EPCQR{}, errors.New("payload is not valid UTF-8")
+	}
+	if lines[3] != "SCT" {
+		return EPCQR{}, fmt.Errorf("invalid identification: %s", lines[3])
+	}
+	for i := range lines {
+		decoded, err := charset.NewDecoder().String(lines[i])
+		if err != nil {
+			return EPCQR{}, fmt.Errorf("decoding line %d: %w", i+1, err)
+		}
+		lines[i] = decoded
+	}
+
+	q := EPCQR{
+		Version:     lines[1],
+		BIC:         lines[4],
+		Name:        lines[5],
+		IBAN:        lines[6],
+		Purpose:     lines[8],
+		Reference:   lines[9],
+		Text:        lines[10],
+		Information: lines[11],
+	}
+	if amount := lines[7]; amount != "" {
+		cents, err := parseEPCAmount(amount)
+		if err != nil {
+			return EPCQR{}, err
+		}
+		q.Cents = cents
+	}
+	return q, q.Validate()
+}
+
+// parseEPCAmount parses an amount like "EUR12.3" exactly.
+func parseEPCAmount(amount string) (int, error) {
+	value, ok := strings.CutPrefix(amount, "EUR")
+	if !ok {
+		return 0, fmt.Errorf("invalid amount currency: %s", amount)
+	}
+	units, fraction, _ := strings.Cut(value, ".")
+	if units == "" || len(fraction) > 2 || !isDigits(units) || !isDigits(fraction) {
+		return 0, fmt.Errorf("invalid amount: %s", amount)
+	}
+	fraction += strings.Repeat("0", 2-len(fraction))
+	cents, err := strconv.Atoi(units + fraction)
+	if err != nil || cents < 1 {
+		return 0, fmt.Errorf("invalid amount: %s", amount)
+	}
+	return cents, nil
+}
+
+// isAlnum returns true if s consists of ASCII letters and digits only.
+func isAlnum(s string) bool {
+	for _, r := range s {
+		if !('0' <= r && r <= '9') && !('A' <= r && r <= 'Z') && !('a' <= r && r <= 'z') {
+			return false
+		}
+	}
+	return true
+}
+
+func isDigits(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,83 @@
+package payment
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestEPCQR(t *testing.T) {
+	// example from EPC069-12
+	example := "BCD\n002\n1\nSCT\nBPOTBEB1\nRed Cross of Belgium\nBE72000000001616\nEUR1\nCHAR\n\nUrgency fund\nSample EPC QR code"
+	q, err := DecodeEPCQR(example)
+	if err != nil {
+		t.Fatalf("decoding: %v", err)
+	}
+	if q.Name != "Red Cross of Belgium" || q.Cents != 100 || q.Purpose != "CHAR" || q.Text != "Urgency fund" {
+		t.Fatalf("got %+v", q)
+	}
+	want := strings.Replace(example, "EUR1\n", "EUR1.00\n", 1)
+	if encoded, err := q.Encode(); err != nil || encoded != want {
+		t.Fatalf("got %q %v, want %q", encoded, err, want)
+	}
+
+	// ISO 8859-1 and creditor reference, BIC and trailing fields omitted
+	q, err = DecodeEPCQR("BCD\r\n002\r\n2\r\nSCT\r\n\r\nM\xfcller\r\nDE89370400440532013000\r\nEUR12.3\r\n\r\nRF18539007547034")
+	if err != nil {
+		t.Fatalf("decoding: %v", err)
+	}
+	if q.Name != "Müller" || q.Cents != 1230 || q.Reference != "RF18539007547034" {
+		t.Fatalf("got %+v", q)
+	}
+
+	decode := []struct {
+		payload string
+		ok      bool
+	}{
+		{example + "\n", true},
+		{example + "\r\n", true},
+		{example + "\n\n", false},
+		{example + "\nextra line", false},
+		{"BCD\n002\n1\nSCT\n", false},
+	}
+	for i, test := range decode {
+		if _, err := DecodeEPCQR(test.payload); (err == nil) != test.ok {
+			t.Fatalf("decode test %d: got %v", i, err)
+		}
+	}
+
+	valid := EPCQR{
+		Version: "002",
+		Name:    "Example",
+		IBAN:    "DE89370400440532013000",
+		Cents:   1000,
+		Text:    "ABCDEF",
+	}
+	if _, err := valid.Encode(); err != nil {
+		t.Fatalf("encoding valid payload: %v", err)
+	}
+
+	invalid := []func(q *EPCQR){
+		func(q *EPCQR) { q.Version = "001" }, // BIC missing
+		func(q *EPCQR) { q.Version = "003" },
+		func(q *EPCQR) { q.BIC = "COBADEF" },
+		func(q *EPCQR) { q.Name = "" },
+		func(q *EPCQR) { q.Name = strings.Repeat("x", 71) },
+		func(q *EPCQR) { q.IBAN = "DE89370400440532013001" }, // checksum
+		func(q *EPCQR) { q.Cents = -1 },
+		func(q *EPCQR) { q.Cents = 100000000000 },
+		func(q *EPCQR) { q.Purpose = "gdsv" },
+		func(q *EPCQR) { q.Reference = "RF18539007547034" }, // together with text
+		func(q *EPCQR) { q.Text = ""; q.Reference = "RF19539007547034" },
+		func(q *EPCQR) { q.Text = strings.Repeat("x", 141) },
+		func(q *EPCQR) { q.Text = "line\nbreak" },
+		func(q *EPCQR) { q.Information = strings.Repeat("x", 71) },
+		func(q *EPCQR) { q.Text = strings.Repeat("ü", 140); q.Information = strings.Repeat("ü", 70) }, // exceeds 331 bytes
+	}
+	for i, modify := range invalid {
+		q := valid
+		modify(&q)
+		if _, err := q.Encode(); err == nil {
+			t.Fatalf("test %d: got nil error", i)
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package payment
+
+import (
+	"math"
+
+	"github.com/dys2p/eco/countries"
+	"github.com/dys2p/eco/euvat"
+)
+
+// A Fee is a surcharge of a payment method or, if negative, a discount. Both parts are added.
+//
+// The difference is a gross amount. A payment surcharge or discount shares the tax treatment of the goods, so VATRate must be the VAT rate of the goods. The default is only right for goods with the standard rate.
+type Fee struct {
+	Percent    float64    // of the total, like 2.5 or -3
+	FixedCents int        // gross, in the minor unit of the purchase currency
+	VATRate    euvat.Rate // optional, default is euvat.RateStandard
+}
+
+// Cents returns the rounded difference for the given gross total. A discount never exceeds the total.
+func (fee Fee) Cents(totalCents int) int {
+	diff := int(math.Round(float64(totalCents)*fee.Percent/100.0)) + fee.FixedCents
+	return max(diff, -totalCents)
+}
+
+// Total returns the adjusted gross total.
+func (fee Fee) Total(totalCents int) int {
+	return totalCents + fee.Cents(totalCents)
+}
+
+// Net returns the net amount and the VAT of the difference in the given purchase country. Both are negative for a discount.
+// The boolean return value indicates if the VAT rate has been found. If it is not found, the maximum rate of the country is used, like in euvat.Rates.Net.
+func (fee Fee) Net(totalCents int, country countries.Country) (net float64, vat float64, ok bool) {
+	rate := fee.VATRate
+	if rate == "" {
+		rate = euvat.RateStandard
+	}
+	gross := fee.Cents(totalCents)
+	net, ok = euvat.Get(country).Net(gross, rate)
+	return net, float64(gross) - net, ok
+}
@@ -0,0 +1,84 @@
+package payment
+
+import (
+	"math"
+	"testing"
+
+	"github.com/dys2p/eco/countries"
+	"github.com/dys2p/eco/euvat"
+	"github.com/dys2p/eco/lang"
+)
+
+func TestFee(t *testing.T) {
+	tests := []struct {
+		fee        Fee
+		totalCents int
+		want       int
+	}{
+		{Fee{}, 10000, 0},
+		{Fee{Percent: 2.5}, 10000, 250},
+		{Fee{Percent: 2.5, FixedCents: 35}, 1001, 60}, // 25.025 rounded to 25
+		{Fee{Percent: -3}, 10000, -300},
+		{Fee{FixedCents: -500}, 300, -300}, // discount never exceeds the total
+	}
+	for _, test := range tests {
+		if got := test.fee.Cents(test.totalCents); got != test.want {
+			t.Fatalf("%+v of %d: got %d, want %d", test.fee, test.totalCents, got, test.want)
+		}
+		if got := test.fee.Total(test.totalCents); got != test.totalCents+test.want {
+			t.Fatalf("%+v of %d: got total %d", test.fee, test.totalCents, got)
+		}
+	}
+
+	net, vat, ok := Fee{FixedCents: 119}.Net(10000, countries.DE)
+	if math.Abs(net-100) > 1e-9 || math.Abs(vat-19) > 1e-9 || !ok {
+		t.Fatalf("got net %f, vat %f, %t", net, vat, ok)
+	}
+	net, vat, ok = Fee{FixedCents: -107, VATRate: euvat.RateReduced1}.Net(10000, countries.DE)
+	if math.Abs(net+100) > 1e-9 || math.Abs(vat+7) > 1e-9 || !ok {
+		t.Fatalf("got net %f, vat %f, %t", net, vat, ok)
+	}
+	if _, _, ok := (Fee{FixedCents: 100, VATRate: "unknown"}).Net(10000, countries.DE); ok {
+		t.Fatal("got ok for unknown VAT rate")
+	}
+
+	eligibility := Eligibility[testPurchase]{
+		Methods: []Method{PayPal{}, SEPA{}, Cash{}},
+		Rules: map[string]Rule[testPurchase]{
+			"paypal-checkout": {Fee: Fee{Percent: 2}},
+			"sepa":            {Fee: Fee{Percent: -2}},
+		},
+	}
+	available, _ := eligibility.Options("", 5000, countries.DE, testPurchase{}, lang.Lang{})
+	want := []struct {
+		html  string
+		total int
+	}{
+		{"+1,00&nbsp;€", 5100},
+		{"−1,00&nbsp;€", 4900},
+		{"", 5000},
+	}
+	for i, option := range available {
+		if string(option.FeeHTML()) != want[i].html || option.TotalCents != want[i].total {
+			t.Fatalf("%s: got %s %d, want %s %d", option.ID(), option.FeeHTML(), option.TotalCents, want[i].html, want[i].total)
+		}
+	}
+}
+
+func TestFeeHTML(t *testing.T) {
+	tests := []struct {
+		option MethodOption
+		want   string
+	}{
+		{MethodOption{FeeCents: 150}, "+1,50&nbsp;€"},
+		{MethodOption{Currency: "EUR", FeeCents: -150}, "−1,50&nbsp;€"},
+		{MethodOption{Currency: "CHF", FeeCents: 150}, "+1.50&nbsp;CHF"},
+		{MethodOption{Currency: "JPY", FeeCents: -150}, "−150&nbsp;JPY"},
+		{MethodOption{Currency: "CHF"}, ""},
+	}
+	for _, test := range tests {
+		if got := string(test.option.FeeHTML()); got != test.want {
+			t.Fatalf("got %s, want %s", got, test.want)
+		}
+	}
+}
@@ -0,0 +1,327 @@
+package payment
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// ErrEventPending is returned by Ledger.Record if the event is being applied by another call. The caller should retry later, e. g. by letting a webhook fail.
+var ErrEventPending = errors.New("payment event is being applied")
+
+// ledgerClaimTimeout is the time after which a pending event is considered abandoned, e. g. because the process has crashed, and is applied again.
+var ledgerClaimTimeout = time.Minute
+
+type EventType string
+
+const (
+	EventProcessing EventType = "processing"
+	EventSettled    EventType = "settled"
+	EventPaid       EventType = "paid"
+	EventRefunded   EventType = "refunded"
+	EventCaptured   EventType = "captured" // recorded by PayPal and Stripe around PaymentSettled and SetPurchasePaid
+)
+
+// An Event is a payment event which has been passed to a PurchaseRepo.
+//
+// The ID is the payment ID for EventCaptured and EventSettled, the refund ID for EventRefunded, and "purchaseID:paymentKey" for EventProcessing and EventPaid.
+type Event struct {
+	Method     string
+	ID         string
+	Type       EventType
+	PurchaseID string
+	PaymentKey string
+	Cents      int    // EventCaptured, EventSettled and EventRefunded only
+	PaidLate   bool   // EventSettled only
+	PaymentID  string // EventRefunded only
+	Time       time.Time
+}
+
+// A Ledger records each (method, ID, event type) once in an SQLite database. Use Wrap in order to turn replayed events into no-ops:
+//
+//	ledger, err := payment.OpenLedger("payments.sqlite3")
+//	if err != nil {
+//		return err
+//	}
+//	method := payment.BTCPay{Purchases: ledger.Wrap(repo)}
+type Ledger struct {
+	sqldb   *sql.DB
+	del     *sql.Stmt
+	done    *sql.Stmt
+	events  *sql.Stmt
+	insert  *sql.Stmt
+	reclaim *sql.Stmt
+	state   *sql.Stmt
+}
+
+func OpenLedger(fpath string) (*Ledger, error) {
+	sqldb, err := sql.Open("sqlite3", fpath+"?_busy_timeout=10000&_journal=WAL&_sync=NORMAL&cache=shared")
+	if err != nil {
+		return nil, fmt.Errorf("opening database %s: %v", fpath, err)
+	}
+
+	if _, err := sqldb.Exec(`
+		create table if not exists payment_events (
+			method      text    not null,
+			id          text    not null,
+			type        text    not null,
+			purchase_id text    not null,
+			payment_key text    not null,
+			cents       integer not null,
+			paid_late   integer not null,
+			payment_id  text    not null,
+			time        integer not null, -- unix timestamp
+			done        integer not null, -- 0 while the event is being applied
+			claimed     integer not null, -- unix timestamp, when the event has been claimed for being applied
+			primary key (method, id, type)
+		);
+		create index if not exists payment_events_purchase_id on payment_events (purchase_id);
+	`); err != nil {
+		return nil, err
+	}
+
+	del, err := sqldb.Prepare("delete from payment_events where method = ? and id = ? and type = ?")
+	if err != nil {
+		return nil, err
+	}
+	done, err := sqldb.Prepare("update payment_events set done = 1 where method = ? and id = ? and type = ?")
+	if err != nil {
+		return nil, err
+	}
+	events, err := sqldb.Prepare("select method, id, type, purchase_id, payment_key, cents, paid_late, payment_id, time from payment_events where purchase_id = ? order by time, rowid")
+	if err != nil {
+		return nil, err
+	}
+	insert, err := sqldb.Prepare("insert or ignore into payment_events (method, id, type, purchase_id, payment_key, cents, paid_late, payment_id, time, done, claimed) values (?, ?, ?, ?, ?, ?, ?, ?, ?, 0, ?)")
+	if err != nil {
+		return nil, err
+	}
+	reclaim, err := sqldb.Prepare("update payment_events set claimed = ? where method = ? and id = ? and type = ? and done = 0 and claimed = ?")
+	if err != nil {
+		return nil, err
+	}
+	state, err := sqldb.Prepare("select done, claimed from payment_events where method = ? and id = ? and type = ?")
+	if err != nil {
+		return nil, err
+	}
+
+	return &Ledger{
+		sqldb:   sqldb,
+		del:     del,
+		done:    done,
+		events:  events,
+		insert:  insert,
+		reclaim: reclaim,
+		state:   state,
+	}, nil
+}
+
+// Events returns all recorded events of a purchase, ordered by time. This includes events which are being applied.
+func (ledger *Ledger) Events(purchaseID string) ([]Event, error) {
+	rows, err := ledger.events.Query(purchaseID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []Event
+	for rows.Next() {
+		var event Event
+		var unix int64
+		if err := rows.Scan(&event.Method, &event.ID, &event.Type, &event.PurchaseID, &event.PaymentKey, &event.Cents, &event.PaidLate, &event.PaymentID, &unix); err != nil {
+			return nil, err
+		}
+		event.Time = time.Unix(unix, 0)
+		events = append(events, event)
+	}
+	return events, rows.Err()
+}
+
+// Record calls fn unless the event has been recorded before.
+//
+// The event is recorded as pending before fn is called, and marked as done after fn has succeeded. If fn returns an error, the event is removed from the ledger, so it can be retried.
+// If the event is pending because another call is applying it, Record returns ErrEventPending. If it has been pending for longer than a minute, e. g. because the process has crashed while applying it, fn is called again.
+func (ledger *Ledger) Record(event Event, fn func() error) error {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	if claimed, err := ledger.claim(event); err != nil {
+		return err
+	} else if !claimed {
+		return nil // replay
+	}
+
+	if err := fn(); err != nil {
+		if _, delErr := ledger.del.Exec(event.Method, event.ID, event.Type); delErr != nil {
+			return fmt.Errorf("%w (and removing %s event %s from ledger: %v)", err, event.Type, event.ID, delErr)
+		}
+		return err
+	}
+	if _, err := ledger.done.Exec(event.Method, event.ID, event.Type); err != nil {
+		return fmt.Errorf("marking %s event %s as done: %w", event.Type, event.ID, err)
+	}
+	return nil
+}
+
+// claim records the event as pending. It returns false if the event is done.
+func (ledger *Ledger) claim(event Event) (bool, error) {
+	now := time.Now()
+	result, err := ledger.insert.Exec(event.Method, event.ID, event.Type, event.PurchaseID, event.PaymentKey, event.Cents, event.PaidLate, event.PaymentID, event.Time.Unix(), now.Unix())
+	if err != nil {
+		return false, fmt.Errorf("recording %s event %s: %w", event.Type, event.ID, err)
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return false, err
+	} else if n == 1 {
+		return true, nil
+	}
+
+	var done bool
+	var claimed int64
+	switch err := ledger.state.QueryRow(event.Method, event.ID, event.Type).Scan(&done, &claimed); err {
+	case nil:
+	case sql.ErrNoRows:
+		return false, ErrEventPending // removed in the meantime because fn has failed
+	default:
+		return false, err
+	}
+	if done {
+		return false, nil
+	}
+	if now.Sub(time.Unix(claimed, 0)) < ledgerClaimTimeout {
+		return false, ErrEventPending
+	}
+
+	// abandoned, claim it again unless another call has been faster
+	result, err = ledger.reclaim.Exec(now.Unix(), event.Method, event.ID, event.Type, claimed)
+	if err != nil {
+		return false, err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return false, err
+	} else if n == 0 {
+		return false, ErrEventPending
+	}
+	return true, nil
+}
+
+// Wrap returns a PurchaseRepo which records events in the ledger and passes them to repo only once.
+func (ledger *Ledger) Wrap(repo PurchaseRepo) PurchaseRepo {
+	return ledgerRepo{
+		PurchaseRepo: repo,
+		ledger:       ledger,
+	}
+}
+
+type ledgerRepo struct {
+	PurchaseRepo
+	ledger *Ledger
+}
+
+func (lr ledgerRepo) PurchaseSum(purchaseID, paymentKey string) (Amount, error) {
+	return PurchaseSum(lr.PurchaseRepo, purchaseID, paymentKey)
+}
+
+func (lr ledgerRepo) PaymentRefunded(purchaseID, paymentKey, methodName, paymentID, refundID string, refundCents int) error {
+	return lr.ledger.Record(Event{
+		Method:     methodName,
+		ID:         refundID,
+		Type:       EventRefunded,
+		PurchaseID: purchaseID,
+		PaymentKey: paymentKey,
+		Cents:      refundCents,
+		PaymentID:  paymentID,
+	}, func() error {
+		return lr.PurchaseRepo.PaymentRefunded(purchaseID, paymentKey, methodName, paymentID, refundID, refundCents)
+	})
+}
+
+func (lr ledgerRepo) PaymentSettled(purchaseID, paymentKey, methodName, paymentID string, paymentCents int, paidLate bool) error {
+	return lr.ledger.Record(Event{
+		Method:     methodName,
+		ID:         paymentID,
+		Type:       EventSettled,
+		PurchaseID: purchaseID,
+		PaymentKey: paymentKey,
+		Cents:      paymentCents,
+		PaidLate:   paidLate,
+	}, func() error {
+		return lr.PurchaseRepo.PaymentSettled(purchaseID, paymentKey, methodName, paymentID, paymentCents, paidLate)
+	})
+}
+
+func (lr ledgerRepo) SetPurchasePaid(purchaseID, paymentKey, methodName string) error {
+	return lr.ledger.Record(Event{
+		Method:     methodName,
+		ID:         purchaseID + ":" + paymentKey,
+		Type:       EventPaid,
+		PurchaseID: purchaseID,
+		PaymentKey: paymentKey,
+	}, func() error {
+		return lr.PurchaseRepo.SetPurchasePaid(purchaseID, paymentKey, methodName)
+	})
+}
+
+func (lr ledgerRepo) SetPurchaseProcessing(purchaseID, paymentKey string) error {
+	return lr.ledger.Record(Event{
+		ID:         purchaseID + ":" + paymentKey,
+		Type:       EventProcessing,
+		PurchaseID: purchaseID,
+		PaymentKey: paymentKey,
+	}, func() error {
+		return lr.PurchaseRepo.SetPurchaseProcessing(purchaseID, paymentKey)
+	})
+}
+
+// capture reports a payment to repo with PaymentSettled and SetPurchasePaid. If ledger is not nil, the payment is recorded as EventCaptured and reported only once.
+func (ledger *Ledger) capture(repo PurchaseRepo, methodName, purchaseID, paymentKey, paymentID string, cents int) error {
+	settle := func() error {
+		if err := repo.PaymentSettled(purchaseID, paymentKey, methodName, paymentID, cents, false); err != nil {
+			return err
+		}
+		return repo.SetPurchasePaid(purchaseID, paymentKey, methodName)
+	}
+	if ledger == nil {
+		return settle()
+	}
+	return ledger.Record(Event{
+		Method:     methodName,
+		ID:         paymentID,
+		Type:       EventCaptured,
+		PurchaseID: purchaseID,
+		PaymentKey: paymentKey,
+		Cents:      cents,
+	}, settle)
+}
+
+// refund reports a refund to repo with PaymentRefunded. If ledger is not nil, the refund is recorded as EventRefunded and reported only once.
+// If repo records its events in the same ledger already, it is called directly, because recording the event twice would return ErrEventPending.
+func (ledger *Ledger) refund(repo PurchaseRepo, methodName, purchaseID, paymentKey, paymentID, refundID string, cents int) error {
+	refunded := func() error {
+		return repo.PaymentRefunded(purchaseID, paymentKey, methodName, paymentID, refundID, cents)
+	}
+	if rec, ok := repo.(ledgerRecorder); ledger == nil || ok && rec.records(ledger) {
+		return refunded()
+	}
+	return ledger.Record(Event{
+		Method:     methodName,
+		ID:         refundID,
+		Type:       EventRefunded,
+		PurchaseID: purchaseID,
+		PaymentKey: paymentKey,
+		Cents:      cents,
+		PaymentID:  paymentID,
+	}, refunded)
+}
+
+// ledgerRecorder is implemented by PurchaseRepos which record their events in a Ledger.
+type ledgerRecorder interface {
+	records(ledger *Ledger) bool
+}
+
+func (lr ledgerRepo) records(ledger *Ledger) bool {
+	return lr.ledger == ledger
+}
@@ -0,0 +1,136 @@
+package payment
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestLedger(t *testing.T) {
+	ledger, err := OpenLedger(filepath.Join(t.TempDir(), "ledger.sqlite3"))
+	if err != nil {
+		t.Fatalf("opening ledger: %v", err)
+	}
+	repo := &testRepo{}
+	wrapped := ledger.Wrap(repo)
+
+	// replays are no-ops
+	for range 2 {
+		wrapped.PaymentSettled("ABC", "key", "BTCPay", "payment-1", 1000, false)
+		wrapped.SetPurchasePaid("ABC", "key", "BTCPay")
+	}
+	repo.check(t, "settled ABC:key BTCPay payment-1 1000 false", "paid ABC:key BTCPay")
+
+	// other payment ID or other method
+	wrapped.PaymentSettled("ABC", "key", "BTCPay", "payment-2", 500, true)
+	wrapped.PaymentSettled("ABC", "key", "PayPal", "payment-2", 500, false)
+	repo.check(t, "settled ABC:key BTCPay payment-2 500 true", "settled ABC:key PayPal payment-2 500 false")
+
+	// failed events can be retried
+	repo.err = errors.New("database is locked")
+	if err := wrapped.PaymentRefunded("ABC", "key", "PayPal", "payment-2", "refund-1", 500); err == nil {
+		t.Fatalf("got nil error")
+	}
+	repo.err = nil
+	if err := wrapped.PaymentRefunded("ABC", "key", "PayPal", "payment-2", "refund-1", 500); err != nil {
+		t.Fatalf("retrying: %v", err)
+	}
+	wrapped.PaymentRefunded("ABC", "key", "PayPal", "payment-2", "refund-1", 500)
+	repo.check(t, "refunded ABC:key PayPal payment-2 refund-1 500", "refunded ABC:key PayPal payment-2 refund-1 500")
+
+	events, err := ledger.Events("ABC")
+	if err != nil {
+		t.Fatalf("getting events: %v", err)
+	}
+	var got []EventType
+	for _, event := range events {
+		got = append(got, event.Type)
+	}
+	want := []EventType{EventSettled, EventPaid, EventSettled, EventSettled, EventRefunded}
+	if !slices.Equal(got, want) {
+		t.Fatalf("got events %v, want %v", got, want)
+	}
+	if events[4].PaymentID != "payment-2" || events[4].Cents != 500 {
+		t.Fatalf("got refund event %+v", events[4])
+	}
+}
+
+func TestLedgerPending(t *testing.T) {
+	ledger, err := OpenLedger(filepath.Join(t.TempDir(), "ledger.sqlite3"))
+	if err != nil {
+		t.Fatalf("opening ledger: %v", err)
+	}
+	event := Event{
+		Method:     "BTCPay",
+		ID:         "payment-1",
+		Type:       EventSettled,
+		PurchaseID: "ABC",
+		PaymentKey: "key",
+		Cents:      1000,
+	}
+
+	// a concurrent duplicate must not report success while the first call is applying the event
+	var calls int
+	err = ledger.Record(event, func() error {
+		calls++
+		if err := ledger.Record(event, func() error {
+			calls++
+			return nil
+		}); !errors.Is(err, ErrEventPending) {
+			t.Fatalf("got %v, want ErrEventPending", err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ledger.Record(event, func() error {
+		calls++
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if calls != 1 {
+		t.Fatalf("got %d calls, want 1", calls)
+	}
+
+	// the process crashes after recording the event, before applying it
+	event.ID = "payment-2"
+	claimed := time.Now().Add(-2 * ledgerClaimTimeout)
+	if _, err := ledger.insert.Exec(event.Method, event.ID, event.Type, event.PurchaseID, event.PaymentKey, event.Cents, event.PaidLate, event.PaymentID, claimed.Unix(), claimed.Unix()); err != nil {
+		t.Fatal(err)
+	}
+	calls = 0
+	for range 2 {
+		if err := ledger.Record(event, func() error {
+			calls++
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if calls != 1 {
+		t.Fatalf("got %d calls, want the abandoned event to be applied once", calls)
+	}
+}
+
+func TestLedgerRefund(t *testing.T) {
+	ledger, err := OpenLedger(filepath.Join(t.TempDir(), "ledger.sqlite3"))
+	if err != nil {
+		t.Fatalf("opening ledger: %v", err)
+	}
+	repo := &testRepo{}
+
+	// a repo which is wrapped with the same ledger records the refund itself
+	for i, purchases := range []PurchaseRepo{repo, ledger.Wrap(repo)} {
+		for range 2 {
+			if err := ledger.refund(purchases, "PayPal", "ABC", "key", "CAPTURE-1", fmt.Sprintf("REFUND-%d", i), 200); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	repo.check(t, "refunded ABC:key PayPal CAPTURE-1 REFUND-0 200", "refunded ABC:key PayPal CAPTURE-1 REFUND-1 200")
+}
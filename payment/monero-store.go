@@ -0,0 +1,211 @@
+package payment
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// a moneroAddress is a subaddress which has been created for a purchase, with the quoted XMR amount
+type moneroAddress struct {
+	PurchaseID   string
+	PaymentKey   string
+	AddressIndex int
+	Address      string
+	Piconero     uint64 // quoted amount
+	Sum          Amount // purchase sum at the time of the quote
+	Quoted       time.Time
+	Processing   bool // a transfer has been seen
+	Paid         bool
+}
+
+// MoneroStore stores the subaddresses and settled transfers of the Monero method in an SQLite database.
+type MoneroStore struct {
+	sqldb      *sql.DB
+	get        *sql.Stmt
+	insert     *sql.Stmt
+	open       *sql.Stmt
+	closed     *sql.Stmt
+	requote    *sql.Stmt
+	processing *sql.Stmt
+	paid       *sql.Stmt
+	settle     *sql.Stmt
+	unsettle   *sql.Stmt
+	received   *sql.Stmt
+}
+
+func OpenMoneroStore(fpath string) (*MoneroStore, error) {
+	sqldb, err := sql.Open("sqlite3", fpath+"?_busy_timeout=10000&_journal=WAL&_sync=NORMAL&cache=shared")
+	if err != nil {
+		return nil, fmt.Errorf("opening database %s: %v", fpath, err)
+	}
+
+	if _, err := sqldb.Exec(`
+		create table if not exists monero_addresses (
+			purchase_id   text    not null,
+			payment_key   text    not null,
+			address_index integer not null unique,
+			address       text    not null,
+			piconero      integer not null,
+			sum_minor     integer not null,
+			currency      text    not null,
+			quoted        integer not null, -- unix timestamp
+			processing    integer not null,
+			paid          integer not null,
+			closed        integer not null, -- purchase has expired or failed before a transfer has been seen
+			primary key (purchase_id, payment_key)
+		);
+		create table if not exists monero_transfers (
+			txid          text    not null,
+			address_index integer not null,
+			piconero      integer not null,
+			primary key (txid, address_index)
+		);
+	`); err != nil {
+		return nil, err
+	}
+
+	get, err := sqldb.Prepare("select purchase_id, payment_key, address_index, address, piconero, sum_minor, currency, quoted, processing, paid from monero_addresses where purchase_id = ? and payment_key = ?")
+	if err != nil {
+		return nil, err
+	}
+	insert, err := sqldb.Prepare("insert into monero_addresses (purchase_id, payment_key, address_index, address, piconero, sum_minor, currency, quoted, processing, paid, closed) values (?, ?, ?, ?, ?, ?, ?, ?, 0, 0, 0)")
+	if err != nil {
+		return nil, err
+	}
+	open, err := sqldb.Prepare("select purchase_id, payment_key, address_index, address, piconero, sum_minor, currency, quoted, processing, paid from monero_addresses where paid = 0 and closed = 0")
+	if err != nil {
+		return nil, err
+	}
+	closed, err := sqldb.Prepare("update monero_addresses set closed = 1 where address_index = ? and processing = 0")
+	if err != nil {
+		return nil, err
+	}
+	requote, err := sqldb.Prepare("update monero_addresses set piconero = ?, sum_minor = ?, currency = ?, quoted = ? where address_index = ? and processing = 0")
+	if err != nil {
+		return nil, err
+	}
+	processing, err := sqldb.Prepare("update monero_addresses set processing = 1 where address_index = ?")
+	if err != nil {
+		return nil, err
+	}
+	paid, err := sqldb.Prepare("update monero_addresses set paid = 1 where address_index = ?")
+	if err != nil {
+		return nil, err
+	}
+	settle, err := sqldb.Prepare("insert or ignore into monero_transfers (txid, address_index, piconero) values (?, ?, ?)")
+	if err != nil {
+		return nil, err
+	}
+	unsettle, err := sqldb.Prepare("delete from monero_transfers where txid = ? and address_index = ?")
+	if err != nil {
+		return nil, err
+	}
+	received, err := sqldb.Prepare("select coalesce(sum(piconero), 0) from monero_transfers where address_index = ?")
+	if err != nil {
+		return nil, err
+	}
+
+	return &MoneroStore{
+		sqldb:      sqldb,
+		get:        get,
+		insert:     insert,
+		open:       open,
+		closed:     closed,
+		requote:    requote,
+		processing: processing,
+		paid:       paid,
+		settle:     settle,
+		unsettle:   unsettle,
+		received:   received,
+	}, nil
+}
+
+func scanMoneroAddress(row interface{ Scan(...any) error }) (moneroAddress, error) {
+	var addr moneroAddress
+	var quoted int64
+	err := row.Scan(&addr.PurchaseID, &addr.PaymentKey, &addr.AddressIndex, &addr.Address, &addr.Piconero, &addr.Sum.Minor, &addr.Sum.Currency, &quoted, &addr.Processing, &addr.Paid)
+	addr.Quoted = time.Unix(quoted, 0)
+	return addr, err
+}
+
+func (store *MoneroStore) address(purchaseID, paymentKey string) (moneroAddress, bool, error) {
+	addr, err := scanMoneroAddress(store.get.QueryRow(purchaseID, paymentKey))
+	switch err {
+	case nil:
+		return addr, true, nil
+	case sql.ErrNoRows:
+		return moneroAddress{}, false, nil
+	default:
+		return moneroAddress{}, false, err
+	}
+}
+
+func (store *MoneroStore) addAddress(addr moneroAddress) error {
+	_, err := store.insert.Exec(addr.PurchaseID, addr.PaymentKey, addr.AddressIndex, addr.Address, addr.Piconero, addr.Sum.Minor, addr.Sum.Currency, addr.Quoted.Unix())
+	return err
+}
+
+// openAddresses returns the addresses of unpaid purchases, except closed addresses.
+func (store *MoneroStore) openAddresses() ([]moneroAddress, error) {
+	rows, err := store.open.Query()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var addrs []moneroAddress
+	for rows.Next() {
+		addr, err := scanMoneroAddress(rows)
+		if err != nil {
+			return nil, err
+		}
+		addrs = append(addrs, addr)
+	}
+	return addrs, rows.Err()
+}
+
+// closeAddress excludes the address from openAddresses unless a transfer has been seen.
+func (store *MoneroStore) closeAddress(addressIndex int) error {
+	_, err := store.closed.Exec(addressIndex)
+	return err
+}
+
+// setQuote updates the quote unless a transfer has been seen.
+func (store *MoneroStore) setQuote(addr moneroAddress) error {
+	_, err := store.requote.Exec(addr.Piconero, addr.Sum.Minor, addr.Sum.Currency, addr.Quoted.Unix(), addr.AddressIndex)
+	return err
+}
+
+func (store *MoneroStore) setProcessing(addressIndex int) error {
+	_, err := store.processing.Exec(addressIndex)
+	return err
+}
+
+func (store *MoneroStore) setPaid(addressIndex int) error {
+	_, err := store.paid.Exec(addressIndex)
+	return err
+}
+
+// settleTransfer records a transfer. It returns false if the transfer has been recorded before.
+func (store *MoneroStore) settleTransfer(txid string, addressIndex int, piconero uint64) (bool, error) {
+	result, err := store.settle.Exec(txid, addressIndex, piconero)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+func (store *MoneroStore) unsettleTransfer(txid string, addressIndex int) error {
+	_, err := store.unsettle.Exec(txid, addressIndex)
+	return err
+}
+
+// receivedPiconero returns the sum of the settled transfers to an address.
+func (store *MoneroStore) receivedPiconero(addressIndex int) (uint64, error) {
+	var piconero uint64
+	return piconero, store.received.QueryRow(addressIndex).Scan(&piconero)
+}
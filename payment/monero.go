@@ -0,0 +1,355 @@
+package payment
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/dys2p/eco/lang"
+	qrcode "github.com/skip2/go-qrcode"
+)
+
+var moneroTmpl = template.Must(template.ParseFS(htmlfiles, "monero.html"))
+
+const piconeroPerXMR = 1e12
+
+type moneroTmplData struct {
+	lang.Lang
+	Address       string
+	Amount        string // XMR
+	Confirmations int
+	Minutes       int    // time until confirmation
+	QRImageSrc    string // base64
+	ValidUntil    string
+}
+
+// Monero creates a subaddress per purchase using a local monero-wallet-rpc, which must be started with --disable-rpc-login or behind a proxy which adds authentication.
+//
+// The purchase sum is converted to XMR when the subaddress is created. If no transfer has arrived when the quote expires, the next PayHTML call quotes the amount again.
+// Call Run or Check in order to watch incoming transfers. Subaddresses are not watched any more once the purchase has expired or failed without a transfer. Transfers are reported to PurchaseRepo.PaymentSettled once they have enough confirmations. Transfers which arrive after the quote has expired are reported as paid late, but still count towards the quoted amount.
+type Monero struct {
+	WalletRPC     string // like "http://127.0.0.1:18082/json_rpc"
+	AccountIndex  int
+	Confirmations int                                    // default: 10
+	QuoteMinutes  int                                    // default: 60
+	Rate          func(currency string) (float64, error) // price of one XMR in the given currency
+	Store         *MoneroStore
+	Purchases     PurchaseRepo
+}
+
+func (m Monero) Handler() http.Handler {
+	var mux = http.NewServeMux()
+	mux.Handle("GET /payment/monero/purchase-status", purchaseStatus(m.Purchases))
+	return mux
+}
+
+// Currencies returns nil because the currencies depend on Rate.
+func (Monero) Currencies() []string {
+	return nil
+}
+
+func (Monero) ID() string {
+	return "monero"
+}
+
+func (Monero) Name(l lang.Lang) string {
+	return l.Tr("Monero")
+}
+
+func (m Monero) confirmations() int {
+	if m.Confirmations <= 0 {
+		return 10
+	}
+	return m.Confirmations
+}
+
+func (m Monero) quoteValidity() time.Duration {
+	if m.QuoteMinutes <= 0 {
+		return time.Hour
+	}
+	return time.Duration(m.QuoteMinutes) * time.Minute
+}
+
+// quote converts the sum to piconero. It rounds up to 0.0001 XMR.
+func (m Monero) quote(sum Amount) (uint64, error) {
+	rate, err := m.Rate(sum.Currency)
+	if err != nil {
+		return 0, err
+	}
+	if rate <= 0 || math.IsInf(rate, 0) || math.IsNaN(rate) {
+		return 0, fmt.Errorf("invalid XMR rate: %f %s", rate, sum.Currency)
+	}
+	return uint64(math.Ceil(sum.Float()/rate*1e4)) * 1e8, nil
+}
+
+func (m Monero) PayHTML(purchaseID, paymentKey, redirectURL string, l lang.Lang) (template.HTML, error) {
+	addr, err := m.address(purchaseID, paymentKey)
+	if err != nil {
+		log.Printf("error getting monero address: %v", err)
+		return template.HTML("Error getting Monero address. We have been notified and will fix it soon. Sorry for the inconvenience."), nil
+	}
+
+	amount := formatPiconero(addr.Piconero)
+	qrPNG, err := qrcode.Encode("monero:"+addr.Address+"?tx_amount="+amount, qrcode.Medium, -5)
+	if err != nil {
+		log.Printf("error creating monero QR code: %v", err) // don't exit
+	}
+
+	buf := &bytes.Buffer{}
+	err = moneroTmpl.Execute(buf, moneroTmplData{
+		Lang:          l,
+		Address:       addr.Address,
+		Amount:        amount,
+		Confirmations: m.confirmations(),
+		Minutes:       2 * m.confirmations(), // Monero block time is two minutes
+		QRImageSrc:    base64.StdEncoding.EncodeToString(qrPNG),
+		ValidUntil:    addr.Quoted.Add(m.quoteValidity()).UTC().Format("2006-01-02 15:04 MST"),
+	})
+	return template.HTML(buf.String()), err
+}
+
+func (Monero) VerifiesAdult() bool {
+	return false
+}
+
+// address returns the subaddress of the purchase. It creates the subaddress if it does not exist, and quotes the amount again if the quote has expired.
+func (m Monero) address(purchaseID, paymentKey string) (moneroAddress, error) {
+	sum, err := PurchaseSum(m.Purchases, purchaseID, paymentKey)
+	if err != nil {
+		return moneroAddress{}, fmt.Errorf("getting purchase sum: %w", err)
+	}
+
+	addr, ok, err := m.Store.address(purchaseID, paymentKey)
+	if err != nil {
+		return moneroAddress{}, fmt.Errorf("getting address from store: %w", err)
+	}
+	if ok {
+		if !addr.Processing && !addr.Paid && time.Since(addr.Quoted) > m.quoteValidity() {
+			piconero, err := m.quote(sum)
+			if err != nil {
+				return moneroAddress{}, fmt.Errorf("quoting: %w", err)
+			}
+			addr.Piconero = piconero
+			addr.Sum = sum
+			addr.Quoted = time.Now()
+			if err := m.Store.setQuote(addr); err != nil {
+				return moneroAddress{}, fmt.Errorf("storing quote: %w", err)
+			}
+		}
+		return addr, nil
+	}
+
+	piconero, err := m.quote(sum)
+	if err != nil {
+		return moneroAddress{}, fmt.Errorf("quoting: %w", err)
+	}
+	var created moneroCreateAddressResult
+	if err := m.rpc("create_address", moneroCreateAddressParams{
+		AccountIndex: m.AccountIndex,
+		Label:        purchaseID,
+	}, &created); err != nil {
+		return moneroAddress{}, err
+	}
+	addr = moneroAddress{
+		PurchaseID:   purchaseID,
+		PaymentKey:   paymentKey,
+		AddressIndex: created.AddressIndex,
+		Address:      created.Address,
+		Piconero:     piconero,
+		Sum:          sum,
+		Quoted:       time.Now(),
+	}
+	if err := m.Store.addAddress(addr); err != nil {
+		return moneroAddress{}, fmt.Errorf("storing address: %w", err)
+	}
+	return addr, nil
+}
+
+// Run starts a goroutine which calls Check at the given interval.
+func (m Monero) Run(interval time.Duration) {
+	go func() {
+		for ; true; time.Sleep(interval) {
+			if err := m.Check(); err != nil {
+				log.Printf("error checking monero transfers: %v", err)
+			}
+		}
+	}()
+}
+
+// Check gets the incoming transfers to the subaddresses of unpaid purchases and reports them to the PurchaseRepo.
+// Subaddresses of expired or failed purchases are closed if no transfer has been seen.
+func (m Monero) Check() error {
+	addrs, err := m.Store.openAddresses()
+	if err != nil {
+		return fmt.Errorf("getting open addresses: %w", err)
+	}
+
+	var errs []error
+	byIndex := make(map[int]moneroAddress)
+	var indices []int
+	for _, addr := range addrs {
+		if !addr.Processing {
+			status, err := m.Purchases.PurchaseStatus(addr.PurchaseID, addr.PaymentKey)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("[%s] getting purchase status: %w", addr.PurchaseID+":"+addr.PaymentKey, err)) // keep watching
+			} else if status == StatusExpired || status == StatusFailed {
+				if err := m.Store.closeAddress(addr.AddressIndex); err != nil {
+					errs = append(errs, fmt.Errorf("[%s] closing address: %w", addr.PurchaseID+":"+addr.PaymentKey, err))
+				}
+				continue
+			}
+		}
+		byIndex[addr.AddressIndex] = addr
+		indices = append(indices, addr.AddressIndex)
+	}
+	if len(indices) == 0 {
+		return errors.Join(errs...)
+	}
+
+	var transfers moneroGetTransfersResult
+	if err := m.rpc("get_transfers", moneroGetTransfersParams{
+		In:             true,
+		Pool:           true,
+		AccountIndex:   m.AccountIndex,
+		SubaddrIndices: indices,
+	}, &transfers); err != nil {
+		return errors.Join(append(errs, err)...)
+	}
+
+	for _, transfer := range append(transfers.In, transfers.Pool...) {
+		addr, ok := byIndex[transfer.SubaddrIndex.Minor]
+		if !ok {
+			continue
+		}
+		reference := addr.PurchaseID + ":" + addr.PaymentKey
+
+		if !addr.Processing {
+			if err := m.Purchases.SetPurchaseProcessing(addr.PurchaseID, addr.PaymentKey); err != nil {
+				errs = append(errs, fmt.Errorf("[%s] setting purchase processing: %w", reference, err))
+				continue
+			}
+			if err := m.Store.setProcessing(addr.AddressIndex); err != nil {
+				errs = append(errs, fmt.Errorf("[%s] storing processing state: %w", reference, err))
+			}
+			addr.Processing = true
+			byIndex[addr.AddressIndex] = addr
+		}
+
+		if transfer.Confirmations < m.confirmations() {
+			continue
+		}
+		if isNew, err := m.Store.settleTransfer(transfer.TxID, addr.AddressIndex, transfer.Amount); err != nil {
+			errs = append(errs, fmt.Errorf("[%s] storing transfer %s: %w", reference, transfer.TxID, err))
+			continue
+		} else if !isNew {
+			continue
+		}
+
+		minor := int(math.Round(float64(addr.Sum.Minor) * float64(transfer.Amount) / float64(addr.Piconero)))
+		paidLate := time.Unix(transfer.Timestamp, 0).After(addr.Quoted.Add(m.quoteValidity()))
+		log.Printf("[%s] settled monero transfer: %s, amount: %s XMR", reference, transfer.TxID, formatPiconero(transfer.Amount))
+		if err := m.Purchases.PaymentSettled(addr.PurchaseID, addr.PaymentKey, "Monero", transfer.TxID, minor, paidLate); err != nil {
+			if unsettleErr := m.Store.unsettleTransfer(transfer.TxID, addr.AddressIndex); unsettleErr != nil {
+				err = fmt.Errorf("%w (and removing transfer from store: %v)", err, unsettleErr)
+			}
+			errs = append(errs, fmt.Errorf("[%s] settling payment %s: %w", reference, transfer.TxID, err))
+		}
+	}
+
+	for _, addr := range byIndex {
+		received, err := m.Store.receivedPiconero(addr.AddressIndex)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if received < addr.Piconero {
+			continue
+		}
+		if err := m.Purchases.SetPurchasePaid(addr.PurchaseID, addr.PaymentKey, "Monero"); err != nil {
+			errs = append(errs, fmt.Errorf("[%s] setting purchase paid: %w", addr.PurchaseID+":"+addr.PaymentKey, err))
+			continue
+		}
+		if err := m.Store.setPaid(addr.AddressIndex); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// formatPiconero formats an amount like "0.1234" XMR.
+func formatPiconero(piconero uint64) string {
+	s := fmt.Sprintf("%d.%012d", piconero/piconeroPerXMR, piconero%piconeroPerXMR)
+	return strings.TrimSuffix(strings.TrimRight(s, "0"), ".")
+}
+
+type moneroRPCRequest struct {
+	JSONRPC string `json:"jsonrpc"`
+	ID      string `json:"id"`
+	Method  string `json:"method"`
+	Params  any    `json:"params"`
+}
+
+type moneroRPCResponse struct {
+	Result any `json:"result"` // set to a pointer before unmarshaling
+	Error  *struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+type moneroCreateAddressParams struct {
+	AccountIndex int    `json:"account_index"`
+	Label        string `json:"label"`
+}
+
+type moneroCreateAddressResult struct {
+	Address      string `json:"address"`
+	AddressIndex int    `json:"address_index"`
+}
+
+type moneroGetTransfersParams struct {
+	In             bool  `json:"in"`
+	Pool           bool  `json:"pool"`
+	AccountIndex   int   `json:"account_index"`
+	SubaddrIndices []int `json:"subaddr_indices"`
+}
+
+type moneroGetTransfersResult struct {
+	In   []moneroTransfer `json:"in"`
+	Pool []moneroTransfer `json:"pool"`
+}
+
+type moneroTransfer struct {
+	Amount        uint64 `json:"amount"` // piconero
+	Confirmations int    `json:"confirmations"`
+	SubaddrIndex  struct {
+		Major int `json:"major"`
+		Minor int `json:"minor"`
+	} `json:"subaddr_index"`
+	Timestamp int64  `json:"timestamp"`
+	TxID      string `json:"txid"`
+}
+
+// rpc calls a monero-wallet-rpc JSON-RPC method.
+func (m Monero) rpc(method string, params, result any) error {
+	resp := moneroRPCResponse{Result: result}
+	if err := doJSON(http.MethodPost, m.WalletRPC, nil, moneroRPCRequest{
+		JSONRPC: "2.0",
+		ID:      "0",
+		Method:  method,
+		Params:  params,
+	}, &resp); err != nil {
+		return fmt.Errorf("calling %s: %w", method, err)
+	}
+	if resp.Error != nil {
+		return fmt.Errorf("calling %s: %d: %s", method, resp.Error.Code, resp.Error.Message)
+	}
+	return nil
+}
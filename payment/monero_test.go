@@ -0,0 +1,182 @@
+package payment
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dys2p/eco/lang"
+	"golang.org/x/text/language"
+	"golang.org/x/text/message"
+)
+
+// fakeWallet is a monero-wallet-rpc JSON-RPC server
+type fakeWallet struct {
+	lock      sync.Mutex
+	addresses int
+	in        []moneroTransfer
+	pool      []moneroTransfer
+}
+
+func (wallet *fakeWallet) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	wallet.lock.Lock()
+	defer wallet.lock.Unlock()
+
+	var req struct {
+		Method string          `json:"method"`
+		Params json.RawMessage `json:"params"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	var result any
+	switch req.Method {
+	case "create_address":
+		wallet.addresses++
+		result = moneroCreateAddressResult{
+			Address:      "8Subaddress" + strings.Repeat("x", wallet.addresses),
+			AddressIndex: wallet.addresses,
+		}
+	case "get_transfers":
+		var params moneroGetTransfersParams
+		json.Unmarshal(req.Params, &params)
+		if !params.In || !params.Pool || len(params.SubaddrIndices) == 0 {
+			json.NewEncoder(w).Encode(map[string]any{"error": map[string]any{"code": -1, "message": "invalid params"}})
+			return
+		}
+		result = moneroGetTransfersResult{In: wallet.in, Pool: wallet.pool}
+	default:
+		json.NewEncoder(w).Encode(map[string]any{"error": map[string]any{"code": -32601, "message": "Method not found"}})
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]any{"jsonrpc": "2.0", "id": "0", "result": result})
+}
+
+func moneroTestTransfer(txid string, index int, piconero uint64, confirmations int) moneroTransfer {
+	transfer := moneroTransfer{
+		Amount:        piconero,
+		Confirmations: confirmations,
+		Timestamp:     time.Now().Unix(),
+		TxID:          txid,
+	}
+	transfer.SubaddrIndex.Minor = index
+	return transfer
+}
+
+func TestMonero(t *testing.T) {
+	wallet := &fakeWallet{}
+	srv := httptest.NewServer(wallet)
+	defer srv.Close()
+
+	store, err := OpenMoneroStore(filepath.Join(t.TempDir(), "monero.sqlite3"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	repo := &testRepo{sumCents: 1500}
+	m := Monero{
+		WalletRPC:     srv.URL,
+		Confirmations: 3,
+		Rate: func(currency string) (float64, error) {
+			return 150, nil // EUR per XMR
+		},
+		Store:     store,
+		Purchases: repo,
+	}
+	l := lang.Lang{Printer: message.NewPrinter(language.English)}
+
+	for range 2 {
+		html, err := m.PayHTML("ABC", "key", "/purchase", l)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, want := range []string{"8Subaddressx", "0.1 XMR", "data:image/png;base64,iVBOR"} {
+			if !strings.Contains(string(html), want) {
+				t.Fatalf("%s not found in %s", want, html)
+			}
+		}
+	}
+	if wallet.addresses != 1 {
+		t.Fatalf("got %d addresses, want 1", wallet.addresses)
+	}
+
+	// no transfers
+	if err := m.Check(); err != nil {
+		t.Fatal(err)
+	}
+	repo.check(t)
+
+	// first transfer in pool
+	wallet.lock.Lock()
+	wallet.pool = []moneroTransfer{moneroTestTransfer("tx1", 1, 6e10, 0)}
+	wallet.lock.Unlock()
+	if err := m.Check(); err != nil {
+		t.Fatal(err)
+	}
+	repo.check(t, "processing ABC:key")
+
+	// first transfer confirmed, second transfer not yet
+	wallet.lock.Lock()
+	wallet.pool = nil
+	wallet.in = []moneroTransfer{moneroTestTransfer("tx1", 1, 6e10, 3), moneroTestTransfer("tx2", 1, 4e10, 2)}
+	wallet.lock.Unlock()
+	if err := m.Check(); err != nil {
+		t.Fatal(err)
+	}
+	repo.check(t, "settled ABC:key Monero tx1 900 false")
+
+	// second transfer confirmed
+	wallet.lock.Lock()
+	wallet.in[1].Confirmations = 3
+	wallet.lock.Unlock()
+	if err := m.Check(); err != nil {
+		t.Fatal(err)
+	}
+	repo.check(t, "settled ABC:key Monero tx2 600 false", "paid ABC:key Monero")
+
+	// paid purchases are not checked again
+	if err := m.Check(); err != nil {
+		t.Fatal(err)
+	}
+	repo.check(t)
+	// addresses of expired purchases are not watched any more
+	if _, err := m.PayHTML("DEF", "key", "/purchase", l); err != nil {
+		t.Fatal(err)
+	}
+	repo.setStatus(StatusExpired)
+	if err := m.Check(); err != nil {
+		t.Fatal(err)
+	}
+	if addrs, err := store.openAddresses(); err != nil || len(addrs) != 0 {
+		t.Fatalf("got open addresses %v, %v", addrs, err)
+	}
+	wallet.lock.Lock()
+	wallet.in = []moneroTransfer{moneroTestTransfer("tx3", 2, 1e11, 3)}
+	wallet.lock.Unlock()
+	if err := m.Check(); err != nil {
+		t.Fatal(err)
+	}
+	repo.check(t)
+}
+
+func TestFormatPiconero(t *testing.T) {
+	tests := map[uint64]string{
+		0:               "0",
+		1:               "0.000000000001",
+		1e11:            "0.1",
+		1234500000000:   "1.2345",
+		100000000000000: "100",
+	}
+	for piconero, want := range tests {
+		if got := formatPiconero(piconero); got != want {
+			t.Fatalf("formatPiconero(%d): got %s, want %s", piconero, got, want)
+		}
+	}
+}
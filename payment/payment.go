@@ -3,10 +3,13 @@
 // Some methods bring their own HTTP endpoints. Register every method under /payment/{method}:
 //
 //	mux.Handle(fmt.Sprintf("/payment/%s/", method.ID()), method.Handler())
+//
+// Every method serves the status of a purchase at /payment/{method}/purchase-status?purchase-id=...&payment-key=..., see StatusHTML.
 package payment
 
 import (
 	"errors"
+	"fmt"
 	"html/template"
 	"net/http"
 
@@ -30,6 +33,22 @@ type Method interface {
 	VerifiesAdult() bool
 }
 
+// A Refunder is a Method which can refund payments.
+//
+// Refund refunds the given amount of a payment. If cents is zero, the full payment is refunded.
+// The payment ID is the one which the method has passed to PurchaseRepo.PaymentSettled.
+// Successful refunds are reported to PurchaseRepo.PaymentRefunded.
+type Refunder interface {
+	Method
+	Refund(purchaseID, paymentKey, paymentID string, cents int) (Refund, error)
+}
+
+type Refund struct {
+	ID       string // assigned by the payment provider
+	Cents    int
+	ClaimURL string // optional, the customer must visit it in order to receive the refund
+}
+
 func Get(methods []Method, id string) (Method, error) {
 	for _, m := range methods {
 		if m.ID() == id {
@@ -46,10 +65,21 @@ func Get(methods []Method, id string) (Method, error) {
 //
 // PaymentSettled also covers late BTCPay payments ("paid late", "AfterExpiration").
 // SetPurchasePaid, however, relies on the invoice settlement configuration of the BTCPay Server.
+//
+// Amounts are in euro cents. If the PurchaseRepo implements CurrencyRepo, they are in the minor unit of the purchase currency instead.
+//
+// Wrap the PurchaseRepo with Ledger.Wrap in order to ignore webhook events which are delivered again.
 type PurchaseRepo interface {
+	PaymentRefunded(purchaseID, paymentKey, methodName, paymentID, refundID string, refundCents int) error
 	PaymentSettled(purchaseID, paymentKey, methodName, paymentID string, paymentCents int, paidLate bool) error
 	PurchaseCreationDate(purchaseID, paymentKey string) (string, error) // yyyy-mm-dd, for exchange rates
+	PurchaseStatus(purchaseID, paymentKey string) (Status, error)       // read-only, for the purchase-status endpoint
 	PurchaseSumCents(purchaseID, paymentKey string) (int, error)
 	SetPurchasePaid(purchaseID, paymentKey, methodName string) error
 	SetPurchaseProcessing(purchaseID, paymentKey string) error
 }
+
+// formatCents formats a non-negative amount like "12.34", which is accepted by payment provider APIs.
+func formatCents(cents int) string {
+	return fmt.Sprintf("%d.%02d", cents/100, cents%100)
+}
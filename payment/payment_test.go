@@ -0,0 +1,71 @@
+package payment
+
+import (
+	"fmt"
+	"slices"
+	"sync"
+	"testing"
+)
+
+// testRepo is a PurchaseRepo which records all calls.
+type testRepo struct {
+	calls        []string
+	creationDate string
+	err          error // returned by all recording methods
+	lock         sync.Mutex
+	status       Status
+	sumCents     int
+}
+
+func (repo *testRepo) record(format string, a ...any) error {
+	repo.lock.Lock()
+	defer repo.lock.Unlock()
+	repo.calls = append(repo.calls, fmt.Sprintf(format, a...))
+	return repo.err
+}
+
+func (repo *testRepo) PaymentRefunded(purchaseID, paymentKey, methodName, paymentID, refundID string, refundCents int) error {
+	return repo.record("refunded %s:%s %s %s %s %d", purchaseID, paymentKey, methodName, paymentID, refundID, refundCents)
+}
+
+func (repo *testRepo) PaymentSettled(purchaseID, paymentKey, methodName, paymentID string, paymentCents int, paidLate bool) error {
+	return repo.record("settled %s:%s %s %s %d %t", purchaseID, paymentKey, methodName, paymentID, paymentCents, paidLate)
+}
+
+func (repo *testRepo) PurchaseCreationDate(purchaseID, paymentKey string) (string, error) {
+	return repo.creationDate, nil
+}
+
+func (repo *testRepo) PurchaseStatus(purchaseID, paymentKey string) (Status, error) {
+	repo.lock.Lock()
+	defer repo.lock.Unlock()
+	return repo.status, nil
+}
+
+func (repo *testRepo) setStatus(status Status) {
+	repo.lock.Lock()
+	defer repo.lock.Unlock()
+	repo.status = status
+}
+
+func (repo *testRepo) PurchaseSumCents(purchaseID, paymentKey string) (int, error) {
+	return repo.sumCents, nil
+}
+
+func (repo *testRepo) SetPurchasePaid(purchaseID, paymentKey, methodName string) error {
+	return repo.record("paid %s:%s %s", purchaseID, paymentKey, methodName)
+}
+
+func (repo *testRepo) SetPurchaseProcessing(purchaseID, paymentKey string) error {
+	return repo.record("processing %s:%s", purchaseID, paymentKey)
+}
+
+func (repo *testRepo) check(t *testing.T, want ...string) {
+	t.Helper()
+	repo.lock.Lock()
+	defer repo.lock.Unlock()
+	if !slices.Equal(repo.calls, want) {
+		t.Fatalf("got calls %q, want %q", repo.calls, want)
+	}
+	repo.calls = nil
+}
@@ -8,8 +8,8 @@ import (
 	"html/template"
 	"io"
 	"log"
-	"math"
 	"net/http"
+	"net/url"
 	"strconv"
 	"strings"
 
@@ -23,15 +23,19 @@ var payPalTmpl = template.Must(template.ParseFS(htmlfiles, "paypal-checkout.html
 type paypalTmplData struct {
 	lang.Lang
 	ClientID  string
+	Currency  string
 	Reference string
 }
 
 // PayPal does the PayPal Standard Checkout described at https://developer.paypal.com/docs/checkout/standard/
 type PayPal struct {
 	Config    *paypal.Config
+	Ledger    *Ledger // required for the webhook, records each capture and refund, so it is reported once although it can be delivered again or reported by both the browser (or Refund) and the webhook
 	Purchases PurchaseRepo
+	WebhookID string // optional, enables the webhook at "/payment/paypal-checkout/webhook" if Ledger is set
 
-	Err func(err error) http.Handler // should write an error message or error template to the ResponseWriter
+	Err        func(err error) http.Handler // should write an error message or error template to the ResponseWriter
+	ErrWebhook func(err error) http.Handler
 }
 
 func (p PayPal) Handler() http.Handler {
@@ -44,12 +48,33 @@ func (p PayPal) Handler() http.Handler {
 		}
 	}
 
+	if p.ErrWebhook == nil {
+		p.ErrWebhook = func(err error) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				log.Printf("error processing PayPal webhook: %v", err)
+				w.WriteHeader(http.StatusInternalServerError) // PayPal will retry
+			})
+		}
+	}
+
 	var mux = http.NewServeMux()
 	mux.Handle("POST /payment/paypal-checkout/create-order", httputil.HandlerFunc(p.createTransaction))
 	mux.Handle("POST /payment/paypal-checkout/capture-order", httputil.HandlerFunc(p.captureTransaction))
+	mux.Handle("GET  /payment/paypal-checkout/purchase-status", purchaseStatus(p.Purchases))
+	if p.WebhookID != "" && p.Ledger == nil {
+		log.Println("not enabling the PayPal webhook because Ledger is nil")
+	}
+	if p.WebhookID != "" && p.Ledger != nil {
+		mux.Handle("POST /payment/paypal-checkout/webhook", httputil.HandlerFunc(p.webhook))
+	}
 	return mux
 }
 
+// Currencies returns the currencies which PayPal supports for payments, see https://developer.paypal.com/api/rest/reference/currency-codes/
+func (PayPal) Currencies() []string {
+	return []string{"AUD", "BRL", "CAD", "CHF", "CNY", "CZK", "DKK", "EUR", "GBP", "HKD", "HUF", "ILS", "JPY", "MXN", "MYR", "NOK", "NZD", "PHP", "PLN", "SEK", "SGD", "THB", "TWD", "USD"}
+}
+
 func (PayPal) ID() string {
 	return "paypal-checkout"
 }
@@ -59,10 +84,17 @@ func (PayPal) Name(l lang.Lang) string {
 }
 
 func (p PayPal) PayHTML(purchaseID, paymentKey, redirectURL string, l lang.Lang) (template.HTML, error) {
+	sum, err := PurchaseSum(p.Purchases, purchaseID, paymentKey)
+	if err != nil {
+		log.Printf("error getting purchase sum from database: %v", err)
+		return template.HTML("Error getting purchase information from database"), nil
+	}
+
 	b := &bytes.Buffer{}
-	err := payPalTmpl.Execute(b, paypalTmplData{
+	err = payPalTmpl.Execute(b, paypalTmplData{
 		Lang:      l,
 		ClientID:  p.Config.ClientID,
+		Currency:  sum.Currency,
 		Reference: purchaseID + ":" + paymentKey,
 	})
 	return template.HTML(b.String()), err
@@ -76,7 +108,7 @@ func (p PayPal) createTransaction(w http.ResponseWriter, r *http.Request) http.H
 	reference, _ := io.ReadAll(r.Body)
 	purchaseID, paymentKey, _ := strings.Cut(string(reference), ":")
 
-	sumCents, err := p.Purchases.PurchaseSumCents(purchaseID, paymentKey)
+	sum, err := PurchaseSum(p.Purchases, purchaseID, paymentKey)
 	if err != nil {
 		return p.Err(fmt.Errorf("getting purchase sum: %w", err))
 	}
@@ -86,7 +118,7 @@ func (p PayPal) createTransaction(w http.ResponseWriter, r *http.Request) http.H
 		return p.Err(err)
 	}
 
-	generateOrderResponse, err := p.Config.CreateOrder(authResult, "Purchase "+purchaseID, purchaseID, paymentKey, sumCents)
+	generateOrderResponse, err := p.createOrder(authResult, "Purchase "+purchaseID, purchaseID, paymentKey, sum)
 	if err != nil {
 		return p.Err(err)
 	}
@@ -101,6 +133,45 @@ func (p PayPal) createTransaction(w http.ResponseWriter, r *http.Request) http.H
 	return nil
 }
 
+type paypalOrderRequest struct {
+	Intent             string                    `json:"intent"`
+	PurchaseUnits      []paypalPurchaseUnit      `json:"purchase_units"`
+	ApplicationContext paypal.ApplicationContext `json:"application_context"`
+}
+
+type paypalPurchaseUnit struct {
+	ReferenceID string       `json:"reference_id,omitempty"` // PayPal fails if it exists but is empty
+	Description string       `json:"description"`
+	InvoiceID   string       `json:"invoice_id"`
+	Amount      paypalAmount `json:"amount"`
+}
+
+// createOrder is like paypal.Config.CreateOrder, but supports other currencies than EUR.
+func (p PayPal) createOrder(authResult *paypal.AuthResult, description, invoiceID, referenceID string, sum Amount) (*paypal.GenerateOrderResponse, error) {
+	var resp paypal.GenerateOrderResponse
+	err := doJSON(http.MethodPost, p.Config.OrderAPI, bearer(authResult), paypalOrderRequest{
+		Intent: "CAPTURE",
+		PurchaseUnits: []paypalPurchaseUnit{
+			{
+				ReferenceID: referenceID,
+				Description: description,
+				InvoiceID:   invoiceID,
+				Amount: paypalAmount{
+					CurrencyCode: sum.Currency,
+					Value:        sum.Decimal(),
+				},
+			},
+		},
+		ApplicationContext: paypal.ApplicationContext{
+			ShippingPreference: "NO_SHIPPING",
+		},
+	}, &resp)
+	if err != nil {
+		return nil, fmt.Errorf("creating order: %w", err)
+	}
+	return &resp, nil
+}
+
 type captureRequest struct {
 	OrderID string `json:"orderID"`
 }
@@ -124,34 +195,119 @@ func (p PayPal) captureTransaction(w http.ResponseWriter, r *http.Request) http.
 		return p.Err(fmt.Errorf("capturing response: %w", err))
 	}
 
+	if err := p.settle(captureReq.OrderID, captureResponse); err != nil {
+		return p.Err(err)
+	}
+
+	// not mentioned in paypal docs: must return some json
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte("true"))
+	return nil
+}
+
+// settle reports the first capture of the first purchase unit to the PurchaseRepo.
+func (p PayPal) settle(orderID string, captureResponse *paypal.CaptureResponse) error {
 	if len(captureResponse.PurchaseUnits) == 0 {
-		return p.Err(errors.New("no purchase units"))
+		return errors.New("no purchase units")
 	}
 	if len(captureResponse.PurchaseUnits[0].Payments.Captures) == 0 {
-		return p.Err(errors.New("no captures"))
+		return errors.New("no captures")
 	}
 
 	var (
-		amountStr  = captureResponse.PurchaseUnits[0].Payments.Captures[0].Amount.Value
+		amount     = captureResponse.PurchaseUnits[0].Payments.Captures[0].Amount
 		captureID  = captureResponse.PurchaseUnits[0].Payments.Captures[0].ID
 		paymentKey = captureResponse.PurchaseUnits[0].ReferenceID
 		purchaseID = captureResponse.PurchaseUnits[0].Payments.Captures[0].InvoiceID
 	)
-	amountEuro, _ := strconv.ParseFloat(amountStr, 64)
-	amountCents := int(math.Round(amountEuro * 100.0))
+	amountCents := paypalAmount{amount.CurrencyCode, amount.Value}.minor()
 
-	log.Printf("[%s] captured transaction: order: %s, capture: %s", purchaseID+":"+paymentKey, captureReq.OrderID, captureID)
+	log.Printf("[%s] captured transaction: order: %s, capture: %s", purchaseID+":"+paymentKey, orderID, captureID)
 
-	if err := p.Purchases.PaymentSettled(purchaseID, paymentKey, "PayPal", captureID, amountCents, false); err != nil {
-		return p.Err(err)
+	return p.settleCapture(purchaseID, paymentKey, captureID, amountCents)
+}
+
+// settleCapture reports a capture to the PurchaseRepo. If p.Ledger is set, it does so only once per capture.
+func (p PayPal) settleCapture(purchaseID, paymentKey, captureID string, amountCents int) error {
+	return p.Ledger.capture(p.Purchases, "PayPal", purchaseID, paymentKey, captureID, amountCents)
+}
+
+// apiURL returns the URL of the given PayPal REST API path. It takes the host from Config.OrderAPI.
+func (p PayPal) apiURL(path string) string {
+	base, _, _ := strings.Cut(p.Config.OrderAPI, "/v2/")
+	return base + path
+}
+
+type paypalAmount struct {
+	CurrencyCode string `json:"currency_code"`
+	Value        string `json:"value"`
+}
+
+// minor returns the amount in the minor unit of its currency. If the currency is missing, EUR is assumed.
+func (amount paypalAmount) minor() int {
+	currency := amount.CurrencyCode
+	if currency == "" {
+		currency = "EUR"
 	}
+	value, _ := strconv.ParseFloat(amount.Value, 64)
+	return FromFloat(value, currency).Minor
+}
 
-	if err := p.Purchases.SetPurchasePaid(purchaseID, paymentKey, "PayPal"); err != nil {
-		return p.Err(err)
+type paypalRefundRequest struct {
+	Amount *paypalAmount `json:"amount,omitempty"` // nil means full refund
+}
+
+type paypalRefundResponse struct {
+	ID     string       `json:"id"`
+	Status string       `json:"status"`
+	Amount paypalAmount `json:"amount"`
+}
+
+// Refund refunds a PayPal capture. The payment ID is the capture ID.
+// Only completed refunds are reported to the PurchaseRepo. Pending refunds are reported by the webhook.
+func (p PayPal) Refund(purchaseID, paymentKey, paymentID string, cents int) (Refund, error) {
+	if cents < 0 {
+		return Refund{}, fmt.Errorf("invalid refund amount: %d", cents)
 	}
 
-	// not mentioned in paypal docs: must return some json
-	w.Header().Set("Content-Type", "application/json")
-	w.Write([]byte("true"))
-	return nil
+	authResult, err := p.Config.Auth()
+	if err != nil {
+		return Refund{}, fmt.Errorf("getting auth: %w", err)
+	}
+
+	var refundReq paypalRefundRequest
+	if cents > 0 {
+		sum, err := PurchaseSum(p.Purchases, purchaseID, paymentKey)
+		if err != nil {
+			return Refund{}, fmt.Errorf("getting currency of purchase: %w", err)
+		}
+		refundReq.Amount = &paypalAmount{
+			CurrencyCode: sum.Currency,
+			Value:        Amount{cents, sum.Currency}.Decimal(),
+		}
+	}
+	var header = bearer(authResult)
+	header.Set("Prefer", "return=representation") // else the response does not contain the amount
+	var refundResp paypalRefundResponse
+	if err := doJSON(http.MethodPost, p.apiURL("/v2/payments/captures/"+url.PathEscape(paymentID)+"/refund"), header, refundReq, &refundResp); err != nil {
+		return Refund{}, fmt.Errorf("refunding capture %s: %w", paymentID, err)
+	}
+
+	refund := Refund{
+		ID:    refundResp.ID,
+		Cents: refundResp.Amount.minor(),
+	}
+	log.Printf("[%s] refunded capture: %s, refund: %s, status: %s", purchaseID+":"+paymentKey, paymentID, refund.ID, refundResp.Status)
+
+	switch refundResp.Status {
+	case "COMPLETED":
+	case "PENDING":
+		return refund, nil // reported by the webhook once it has completed
+	default:
+		return refund, fmt.Errorf("refund %s of capture %s has status %s", refund.ID, paymentID, refundResp.Status)
+	}
+	if err := p.Ledger.refund(p.Purchases, "PayPal", purchaseID, paymentKey, paymentID, refund.ID, refund.Cents); err != nil {
+		return refund, err
+	}
+	return refund, nil
 }
@@ -0,0 +1,196 @@
+package payment
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"path"
+
+	"github.com/dys2p/go-paypal"
+)
+
+type paypalWebhookEvent struct {
+	ID        string          `json:"id"`
+	EventType string          `json:"event_type"`
+	Resource  json.RawMessage `json:"resource"`
+}
+
+type paypalVerifyRequest struct {
+	AuthAlgo         string          `json:"auth_algo"`
+	CertURL          string          `json:"cert_url"`
+	TransmissionID   string          `json:"transmission_id"`
+	TransmissionSig  string          `json:"transmission_sig"`
+	TransmissionTime string          `json:"transmission_time"`
+	WebhookID        string          `json:"webhook_id"`
+	WebhookEvent     json.RawMessage `json:"webhook_event"`
+}
+
+type paypalVerifyResponse struct {
+	VerificationStatus string `json:"verification_status"`
+}
+
+type paypalLink struct {
+	Href string `json:"href"`
+	Rel  string `json:"rel"`
+}
+
+// capture or refund resource
+type paypalResource struct {
+	ID                string       `json:"id"`
+	Status            string       `json:"status"`
+	Amount            paypalAmount `json:"amount"`
+	InvoiceID         string       `json:"invoice_id"`
+	Links             []paypalLink `json:"links"`
+	SupplementaryData struct {
+		RelatedIDs struct {
+			OrderID string `json:"order_id"`
+		} `json:"related_ids"`
+	} `json:"supplementary_data"`
+}
+
+type paypalOrder struct {
+	ID            string `json:"id"`
+	Status        string `json:"status"`
+	PurchaseUnits []struct {
+		ReferenceID string `json:"reference_id"`
+		InvoiceID   string `json:"invoice_id"`
+	} `json:"purchase_units"`
+}
+
+// webhook is a fallback for missed client-side captures. Its URL is "/payment/paypal-checkout/webhook", its events are "Checkout order approved", "Payment capture completed", "Payment capture denied" and "Payment capture refunded".
+//
+// Events can be delivered more than once, a capture can be reported by both the webhook and captureTransaction, and a refund by both the webhook and Refund. Captures and refunds are deduplicated with p.Ledger.
+func (p PayPal) webhook(w http.ResponseWriter, r *http.Request) http.Handler {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return p.ErrWebhook(fmt.Errorf("reading webhook body: %w", err))
+	}
+
+	authResult, err := p.Config.Auth()
+	if err != nil {
+		return p.ErrWebhook(fmt.Errorf("getting auth: %w", err))
+	}
+
+	// verify event, see https://developer.paypal.com/docs/api/webhooks/v1/#verify-webhook-signature_post
+	var verifyResp paypalVerifyResponse
+	if err := doJSON(http.MethodPost, p.apiURL("/v1/notifications/verify-webhook-signature"), bearer(authResult), paypalVerifyRequest{
+		AuthAlgo:         r.Header.Get("PAYPAL-AUTH-ALGO"),
+		CertURL:          r.Header.Get("PAYPAL-CERT-URL"),
+		TransmissionID:   r.Header.Get("PAYPAL-TRANSMISSION-ID"),
+		TransmissionSig:  r.Header.Get("PAYPAL-TRANSMISSION-SIG"),
+		TransmissionTime: r.Header.Get("PAYPAL-TRANSMISSION-TIME"),
+		WebhookID:        p.WebhookID,
+		WebhookEvent:     body,
+	}, &verifyResp); err != nil {
+		return p.ErrWebhook(fmt.Errorf("verifying webhook signature: %w", err))
+	}
+	if verifyResp.VerificationStatus != "SUCCESS" {
+		return p.ErrWebhook(fmt.Errorf("webhook signature verification status: %s", verifyResp.VerificationStatus))
+	}
+
+	var event paypalWebhookEvent
+	if err := json.Unmarshal(body, &event); err != nil {
+		return p.ErrWebhook(fmt.Errorf("unmarshaling webhook event: %w", err))
+	}
+	var resource paypalResource
+	if err := json.Unmarshal(event.Resource, &resource); err != nil {
+		return p.ErrWebhook(fmt.Errorf("unmarshaling webhook event resource: %w", err))
+	}
+
+	switch event.EventType {
+	case "CHECKOUT.ORDER.APPROVED":
+		// the order has been approved, but the browser might not have called capture-order
+		var captureResponse paypal.CaptureResponse
+		if err := doJSON(http.MethodPost, p.Config.OrderAPI+"/"+url.PathEscape(resource.ID)+"/capture", bearer(authResult), struct{}{}, &captureResponse); err != nil {
+			if paypalIssue(err) == "ORDER_ALREADY_CAPTURED" {
+				return nil // captured by captureTransaction
+			}
+			return p.ErrWebhook(fmt.Errorf("capturing order %s: %w", resource.ID, err))
+		}
+		if err := p.settle(resource.ID, &captureResponse); err != nil {
+			return p.ErrWebhook(err)
+		}
+	case "PAYMENT.CAPTURE.COMPLETED":
+		purchaseID, paymentKey, err := p.orderReference(authResult, resource.SupplementaryData.RelatedIDs.OrderID)
+		if err != nil {
+			return p.ErrWebhook(err)
+		}
+		if err := p.settleCapture(purchaseID, paymentKey, resource.ID, resource.Amount.minor()); err != nil {
+			return p.ErrWebhook(err)
+		}
+	case "PAYMENT.CAPTURE.DENIED":
+		// The purchase stays unpaid, so the customer can pay again. It might remain in processing because the PurchaseRepo can't undo SetPurchaseProcessing.
+		log.Printf("[%s] paypal capture denied: %s", resource.InvoiceID, resource.ID)
+	case "PAYMENT.CAPTURE.REFUNDED":
+		// resource is the refund, the "up" link points to the capture
+		var captureURL string
+		for _, link := range resource.Links {
+			if link.Rel == "up" {
+				captureURL = link.Href
+			}
+		}
+		if captureURL == "" {
+			return p.ErrWebhook(fmt.Errorf("refund %s has no capture link", resource.ID))
+		}
+		var capture paypalResource
+		if err := doJSON(http.MethodGet, p.apiURL("/v2/payments/captures/"+url.PathEscape(path.Base(captureURL))), bearer(authResult), nil, &capture); err != nil {
+			return p.ErrWebhook(fmt.Errorf("getting capture of refund %s: %w", resource.ID, err))
+		}
+		purchaseID, paymentKey, err := p.orderReference(authResult, capture.SupplementaryData.RelatedIDs.OrderID)
+		if err != nil {
+			return p.ErrWebhook(err)
+		}
+		if err := p.Ledger.refund(p.Purchases, "PayPal", purchaseID, paymentKey, capture.ID, resource.ID, resource.Amount.minor()); err != nil {
+			return p.ErrWebhook(err)
+		}
+	default:
+		log.Printf("unknown paypal webhook event type: %s", event.EventType)
+	}
+
+	return nil
+}
+
+type paypalErrorResponse struct {
+	Name    string `json:"name"`
+	Details []struct {
+		Issue string `json:"issue"`
+	} `json:"details"`
+}
+
+// paypalIssue returns the first issue of a PayPal API error response, see https://developer.paypal.com/api/rest/responses/#link-errorresponse
+func paypalIssue(err error) string {
+	var statusErr *statusError
+	if !errors.As(err, &statusErr) {
+		return ""
+	}
+	var resp paypalErrorResponse
+	if json.Unmarshal(statusErr.Body, &resp) != nil || len(resp.Details) == 0 {
+		return ""
+	}
+	return resp.Details[0].Issue
+}
+
+// orderReference returns the purchase ID and payment key of an order.
+func (p PayPal) orderReference(authResult *paypal.AuthResult, orderID string) (string, string, error) {
+	if orderID == "" {
+		return "", "", errors.New("missing order ID")
+	}
+	var order paypalOrder
+	if err := doJSON(http.MethodGet, p.Config.OrderAPI+"/"+url.PathEscape(orderID), bearer(authResult), nil, &order); err != nil {
+		return "", "", fmt.Errorf("getting order %s: %w", orderID, err)
+	}
+	if len(order.PurchaseUnits) == 0 {
+		return "", "", fmt.Errorf("order %s has no purchase units", orderID)
+	}
+	return order.PurchaseUnits[0].InvoiceID, order.PurchaseUnits[0].ReferenceID, nil
+}
+
+func bearer(authResult *paypal.AuthResult) http.Header {
+	var header = http.Header{}
+	header.Set("Authorization", "Bearer "+authResult.AccessToken)
+	return header
+}
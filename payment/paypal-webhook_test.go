@@ -0,0 +1,124 @@
+package payment
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/dys2p/go-paypal"
+)
+
+func TestPayPalWebhook(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /v1/oauth2/token", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"access_token": "test-token"}`))
+	})
+	mux.HandleFunc("POST /v1/notifications/verify-webhook-signature", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer test-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		var req paypalVerifyRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.TransmissionSig != "valid" || req.WebhookID != "webhook-1" {
+			w.Write([]byte(`{"verification_status": "FAILURE"}`))
+			return
+		}
+		w.Write([]byte(`{"verification_status": "SUCCESS"}`))
+	})
+	mux.HandleFunc("POST /v2/checkout/orders/ORDER-1/capture", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"id": "ORDER-1", "status": "COMPLETED", "purchase_units": [{"reference_id": "key", "payments": {"captures": [{"id": "CAPTURE-1", "status": "COMPLETED", "amount": {"currency_code": "EUR", "value": "12.34"}, "invoice_id": "ABC"}]}}]}`))
+	})
+	mux.HandleFunc("POST /v2/checkout/orders/ORDER-2/capture", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		w.Write([]byte(`{"name": "UNPROCESSABLE_ENTITY", "details": [{"issue": "ORDER_ALREADY_CAPTURED"}]}`))
+	})
+	mux.HandleFunc("GET /v2/checkout/orders/ORDER-1", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"id": "ORDER-1", "status": "COMPLETED", "purchase_units": [{"reference_id": "key", "invoice_id": "ABC"}]}`))
+	})
+	mux.HandleFunc("GET /v2/payments/captures/CAPTURE-1", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"id": "CAPTURE-1", "status": "REFUNDED", "supplementary_data": {"related_ids": {"order_id": "ORDER-1"}}}`))
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	ledger, err := OpenLedger(filepath.Join(t.TempDir(), "ledger.sqlite3"))
+	if err != nil {
+		t.Fatalf("opening ledger: %v", err)
+	}
+
+	repo := &testRepo{}
+	handler := PayPal{
+		Config: &paypal.Config{
+			OAuthAPI: srv.URL + "/v1/oauth2/token",
+			OrderAPI: srv.URL + "/v2/checkout/orders",
+		},
+		Ledger:    ledger,
+		Purchases: repo,
+		WebhookID: "webhook-1",
+	}.Handler()
+
+	tests := []struct {
+		sig        string
+		event      string
+		wantStatus int
+		wantCalls  []string
+	}{
+		{
+			"invalid",
+			`{"id": "WH-1", "event_type": "PAYMENT.CAPTURE.COMPLETED", "resource": {"id": "CAPTURE-1", "amount": {"value": "12.34"}, "supplementary_data": {"related_ids": {"order_id": "ORDER-1"}}}}`,
+			http.StatusInternalServerError,
+			nil,
+		},
+		{
+			"valid",
+			`{"id": "WH-2", "event_type": "CHECKOUT.ORDER.APPROVED", "resource": {"id": "ORDER-1", "status": "APPROVED"}}`,
+			http.StatusOK,
+			[]string{"settled ABC:key PayPal CAPTURE-1 1234 false", "paid ABC:key PayPal"},
+		},
+		{
+			"valid",
+			`{"id": "WH-3", "event_type": "CHECKOUT.ORDER.APPROVED", "resource": {"id": "ORDER-2", "status": "APPROVED"}}`,
+			http.StatusOK,
+			nil,
+		},
+		{
+			"valid",
+			`{"id": "WH-4", "event_type": "PAYMENT.CAPTURE.COMPLETED", "resource": {"id": "CAPTURE-1", "amount": {"value": "12.34"}, "supplementary_data": {"related_ids": {"order_id": "ORDER-1"}}}}`,
+			http.StatusOK,
+			nil, // settled by WH-2 already
+		},
+		{
+			"valid",
+			`{"id": "WH-2", "event_type": "CHECKOUT.ORDER.APPROVED", "resource": {"id": "ORDER-1", "status": "APPROVED"}}`,
+			http.StatusOK,
+			nil, // delivered again
+		},
+		{
+			"valid",
+			`{"id": "WH-5", "event_type": "PAYMENT.CAPTURE.REFUNDED", "resource": {"id": "REFUND-1", "amount": {"value": "2.00"}, "links": [{"rel": "up", "href": "` + srv.URL + `/v2/payments/captures/CAPTURE-1"}]}}`,
+			http.StatusOK,
+			[]string{"refunded ABC:key PayPal CAPTURE-1 REFUND-1 200"},
+		},
+		{
+			"valid",
+			`{"id": "WH-5", "event_type": "PAYMENT.CAPTURE.REFUNDED", "resource": {"id": "REFUND-1", "amount": {"value": "2.00"}, "links": [{"rel": "up", "href": "` + srv.URL + `/v2/payments/captures/CAPTURE-1"}]}}`,
+			http.StatusOK,
+			nil, // delivered again
+		},
+	}
+
+	for _, test := range tests {
+		r := httptest.NewRequest(http.MethodPost, "/payment/paypal-checkout/webhook", strings.NewReader(test.event))
+		r.Header.Set("PAYPAL-TRANSMISSION-SIG", test.sig)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if w.Code != test.wantStatus {
+			t.Fatalf("got status %d, want %d", w.Code, test.wantStatus)
+		}
+		repo.check(t, test.wantCalls...)
+	}
+}
@@ -0,0 +1,138 @@
+package rates
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	ECBDailyURL   = "https://www.ecb.europa.eu/stats/eurofxref/eurofxref-daily.xml"
+	ECBHistoryURL = "https://www.ecb.europa.eu/stats/eurofxref/eurofxref-hist-90d.xml"
+)
+
+var ecbClient = &http.Client{Timeout: 30 * time.Second}
+
+// ecbNow returns the current time. It is replaced in tests.
+var ecbNow = time.Now
+
+// ECB gets the euro foreign exchange reference rates of the European Central Bank, which are published on working days around 16:00 CET.
+// The rates are units of foreign currency per euro. Margin is added to them, so they can be used as buy rates for foreign cash:
+//
+//	ecb := rates.ECB{Currencies: []string{"CHF", "GBP", "USD"}, Margin: 3}
+//	history, err := rates.MakeAndRun("rates.sqlite3", ecb.GetBuyRates)
+//	if err != nil {
+//		return err
+//	}
+//	if err := ecb.Backfill(history); err != nil {
+//		log.Printf("error backfilling rates: %v", err)
+//	}
+type ECB struct {
+	Currencies []string // optional, default: all currencies published by the ECB
+	Margin     float64  // percent, e. g. 3 means that customers pay 3% more units of foreign currency
+	DailyURL   string   // default: ECBDailyURL
+	HistoryURL string   // default: ECBHistoryURL
+}
+
+// GetBuyRates gets the reference rates of the current day and adds the margin. It can be passed to MakeAndRun.
+// Before the rates of the current day are published, and on days without rates like weekends, it returns an error, so History doesn't store older rates under the current date.
+func (ecb ECB) GetBuyRates() (map[string]float64, error) {
+	days, err := ecb.get(ecb.DailyURL, ECBDailyURL)
+	if err != nil {
+		return nil, err
+	}
+	if len(days) == 0 {
+		return nil, errors.New("no rates found")
+	}
+	if today := ecbNow().Format("2006-01-02"); days[0].date != today {
+		return nil, fmt.Errorf("rates of %s not yet published, latest rates are of %s", today, days[0].date)
+	}
+	return days[0].rates, nil
+}
+
+// History gets the reference rates of the last 90 days and adds the margin. The result maps dates to rates.
+func (ecb ECB) History() (map[string]map[string]float64, error) {
+	days, err := ecb.get(ecb.HistoryURL, ECBHistoryURL)
+	if err != nil {
+		return nil, err
+	}
+	var result = make(map[string]map[string]float64)
+	for _, day := range days {
+		result[day.date] = day.rates
+	}
+	return result, nil
+}
+
+// Backfill passes the reference rates of the last 90 days to History.Backfill, so they are checked like the daily rates.
+func (ecb ECB) Backfill(h *History) error {
+	days, err := ecb.History()
+	if err != nil {
+		return err
+	}
+	return h.Backfill(days)
+}
+
+type ecbDay struct {
+	date  string
+	rates map[string]float64
+}
+
+type ecbEnvelope struct {
+	Cube struct {
+		Days []struct {
+			Time  string `xml:"time,attr"`
+			Rates []struct {
+				Currency string  `xml:"currency,attr"`
+				Rate     float64 `xml:"rate,attr"`
+			} `xml:"Cube"`
+		} `xml:"Cube"`
+	} `xml:"Cube"`
+}
+
+// get fetches and parses an ECB XML file. The days are sorted from newest to oldest.
+func (ecb ECB) get(url, defaultURL string) ([]ecbDay, error) {
+	if url == "" {
+		url = defaultURL
+	}
+	resp, err := ecbClient.Get(url)
+	if err != nil {
+		return nil, fmt.Errorf("getting %s: %w", url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("getting %s: %s", url, resp.Status)
+	}
+
+	var envelope ecbEnvelope
+	if err := xml.NewDecoder(resp.Body).Decode(&envelope); err != nil {
+		return nil, fmt.Errorf("decoding %s: %w", url, err)
+	}
+
+	var days []ecbDay
+	for _, d := range envelope.Cube.Days {
+		if _, err := time.Parse("2006-01-02", d.Time); err != nil {
+			return nil, fmt.Errorf("invalid date in %s: %s", url, d.Time)
+		}
+		var rates = make(map[string]float64)
+		for _, r := range d.Rates {
+			if r.Rate <= 0 {
+				return nil, fmt.Errorf("invalid %s rate on %s: %f", r.Currency, d.Time, r.Rate)
+			}
+			if len(ecb.Currencies) > 0 && !slices.Contains(ecb.Currencies, r.Currency) {
+				continue
+			}
+			rates[r.Currency] = r.Rate * (1 + ecb.Margin/100.0)
+		}
+		if len(rates) > 0 {
+			days = append(days, ecbDay{d.Time, rates})
+		}
+	}
+	slices.SortFunc(days, func(a, b ecbDay) int {
+		return -strings.Compare(a.date, b.date)
+	})
+	return days, nil
+}
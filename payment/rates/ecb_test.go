@@ -0,0 +1,120 @@
+package rates
+
+import (
+	"database/sql"
+	"errors"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestECB(t *testing.T) {
+	t.Cleanup(func() { ecbNow = time.Now })
+	ecbNow = func() time.Time { return time.Date(2024, 5, 10, 17, 0, 0, 0, time.Local) }
+
+	srv := httptest.NewServer(http.FileServer(http.Dir("testdata")))
+	defer srv.Close()
+
+	ecb := ECB{
+		Currencies: []string{"CHF", "JPY", "USD"},
+		Margin:     2,
+		DailyURL:   srv.URL + "/eurofxref-daily.xml",
+		HistoryURL: srv.URL + "/eurofxref-hist-90d.xml",
+	}
+
+	got, err := ecb.GetBuyRates()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]float64{"CHF": 0.99603, "JPY": 171.1662, "USD": 1.098744}
+	if len(got) != len(want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	for currency, rate := range want {
+		if math.Abs(got[currency]-rate) > 0.000001 {
+			t.Fatalf("got %s %f, want %f", currency, got[currency], rate)
+		}
+	}
+
+	db, err := OpenDB(filepath.Join(t.TempDir(), "rates.sqlite3"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Insert("2024-05-08", map[string]float64{"USD": 1.5}); err != nil {
+		t.Fatal(err)
+	}
+	h := &History{Database: db, MaxChange: 5}
+	if err := ecb.Backfill(h); err != nil {
+		t.Fatal(err)
+	}
+	pending, err := db.Pending()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 2 || pending[0].Date != "2024-05-09" || pending[0].Reason != "USD changed by -26.9% since 2024-05-08" || pending[1].Date != "2024-05-10" {
+		t.Fatalf("got pending %+v", pending)
+	}
+
+	handler := h.AdminHandler()
+	for _, body := range []string{`{"date": "2024-05-09", "action": "reject"}`, `{"date": "2024-05-10", "action": "approve"}`} {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/admin/rates", strings.NewReader(body)))
+		if w.Code != http.StatusNoContent {
+			t.Fatalf("%s: got %d %s", body, w.Code, w.Body)
+		}
+	}
+	if pending, _ := db.Pending(); len(pending) != 0 {
+		t.Fatalf("got pending %+v after approval", pending)
+	}
+	if _, err := db.Get("2024-05-09"); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("got %v, want ErrNoRows for the rejected day", err)
+	}
+
+	for date, wantUSD := range map[string]float64{
+		"2024-05-07": 1.0783 * 1.02,
+		"2024-05-08": 1.5, // not modified
+		"2024-05-10": 1.0772 * 1.02,
+	} {
+		rates, err := db.Get(date)
+		if err != nil {
+			t.Fatalf("getting %s: %v", date, err)
+		}
+		if math.Abs(rates["USD"]-wantUSD) > 0.000001 {
+			t.Fatalf("%s: got USD %f, want %f", date, rates["USD"], wantUSD)
+		}
+		if _, ok := rates["GBP"]; ok {
+			t.Fatalf("%s: got GBP, want filtered", date)
+		}
+	}
+	if latest, err := db.LatestDate("2024-05-12"); err != nil || latest != "2024-05-10" {
+		t.Fatalf("got latest date %s, %v", latest, err)
+	}
+
+	// backfilled days can not be compared with other sources
+	db, err = OpenDB(filepath.Join(t.TempDir(), "sources.sqlite3"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h = &History{Database: db, Sources: []func() (map[string]float64, error){ecb.GetBuyRates}}
+	if err := ecb.Backfill(h); err != nil {
+		t.Fatal(err)
+	}
+	if pending, _ := db.Pending(); len(pending) != 4 || pending[0].Reason != "backfilled from a single source" {
+		t.Fatalf("got pending %+v", pending)
+	}
+
+	// the rates of the next day are not published yet
+	ecbNow = func() time.Time { return time.Date(2024, 5, 11, 17, 0, 0, 0, time.Local) }
+	if _, err := ecb.GetBuyRates(); err == nil {
+		t.Fatal("got no error for outdated rates")
+	}
+
+	ecb.DailyURL = srv.URL + "/not-found.xml"
+	if _, err := ecb.GetBuyRates(); err == nil {
+		t.Fatal("got no error for missing file")
+	}
+}
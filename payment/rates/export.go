@@ -0,0 +1,221 @@
+package rates
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var currencyRegexp = regexp.MustCompile(`^[A-Z]{3}$`)
+
+// ExportCSV writes the rates from and to the given dates, inclusive. The first row contains "date", "version" and the currencies.
+func (h *History) ExportCSV(w io.Writer, from, to string) error {
+	days, err := h.Database.Range(from, to)
+	if err != nil {
+		return err
+	}
+	return writeCSV(w, days)
+}
+
+func writeCSV(w io.Writer, days []Day) error {
+	var currencies []string
+	for _, day := range days {
+		for currency := range day.Rates {
+			if !slices.Contains(currencies, currency) {
+				currencies = append(currencies, currency)
+			}
+		}
+	}
+	slices.Sort(currencies)
+
+	cw := csv.NewWriter(w)
+	cw.Write(append([]string{"date", "version"}, currencies...))
+	for _, day := range days {
+		record := []string{day.Date, strconv.Itoa(day.Version)}
+		for _, currency := range currencies {
+			if rate, ok := day.Rates[currency]; ok {
+				record = append(record, strconv.FormatFloat(rate, 'f', -1, 64))
+			} else {
+				record = append(record, "")
+			}
+		}
+		cw.Write(record)
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// ExportJSON writes the rates from and to the given dates, inclusive, as a JSON array of days.
+func (h *History) ExportJSON(w io.Writer, from, to string) error {
+	days, err := h.Database.Range(from, to)
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, days)
+}
+
+func writeJSON(w io.Writer, days []Day) error {
+	if days == nil {
+		days = []Day{} // encode as [], not null
+	}
+	return json.NewEncoder(w).Encode(days)
+}
+
+// ExportHandler serves ExportCSV or ExportJSON, depending on the "format" query parameter ("csv" or "json"). The "from" and "to" query parameters default to the last 90 days.
+func (h *History) ExportHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		from, to, ok := dateRange(w, r)
+		if !ok {
+			return
+		}
+		format := r.URL.Query().Get("format")
+		if format != "csv" && format != "json" && format != "" {
+			http.Error(w, "unknown format", http.StatusBadRequest)
+			return
+		}
+		// load the days before writing the headers, so a database error can be reported
+		days, err := h.Database.Range(from, to)
+		if err != nil {
+			log.Printf("\033[31m"+"error getting rates for export: %v"+"\033[0m", err)
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+			return
+		}
+		if format == "csv" {
+			w.Header().Add("Content-Type", "text/csv; charset=utf-8")
+			w.Header().Add("Content-Disposition", fmt.Sprintf(`attachment; filename="rates-%s-%s.csv"`, from, to))
+			err = writeCSV(w, days)
+		} else {
+			w.Header().Add("Content-Type", "application/json")
+			err = writeJSON(w, days)
+		}
+		if err != nil {
+			log.Printf("\033[31m"+"error exporting rates: %v"+"\033[0m", err)
+		}
+	}
+}
+
+// ChartHandler renders an SVG line chart of one currency, given by the "currency" query parameter. The "from" and "to" query parameters default to the last 90 days.
+// The chart does not require JavaScript, so it can be embedded with an img element:
+//
+//	mux.Handle("GET /rates/synced", history.SyncedHandler())
+//	mux.Handle("GET /rates/chart.svg", history.ChartHandler()) // <img src="/rates/chart.svg?currency=USD">
+func (h *History) ChartHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		currency := r.URL.Query().Get("currency")
+		if !currencyRegexp.MatchString(currency) {
+			http.Error(w, "invalid currency", http.StatusBadRequest)
+			return
+		}
+		from, to, ok := dateRange(w, r)
+		if !ok {
+			return
+		}
+		days, err := h.Database.Range(from, to)
+		if err != nil {
+			log.Printf("\033[31m"+"error getting rates for chart: %v"+"\033[0m", err)
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Add("Content-Type", "image/svg+xml")
+		w.Header().Add("Cache-Control", "max-age=3600")
+		io.WriteString(w, chartSVG(currency, from, to, days))
+	}
+}
+
+// dateRange gets the "from" and "to" query parameters. It writes an error and returns false if they are invalid.
+func dateRange(w http.ResponseWriter, r *http.Request) (string, string, bool) {
+	from := r.URL.Query().Get("from")
+	to := r.URL.Query().Get("to")
+	if to == "" {
+		to = time.Now().Format("2006-01-02")
+	}
+	toTime, err := time.Parse("2006-01-02", to)
+	if err != nil {
+		http.Error(w, "invalid date", http.StatusBadRequest)
+		return "", "", false
+	}
+	if from == "" {
+		from = toTime.AddDate(0, 0, -90).Format("2006-01-02")
+	}
+	if _, err := time.Parse("2006-01-02", from); err != nil || from > to {
+		http.Error(w, "invalid date", http.StatusBadRequest)
+		return "", "", false
+	}
+	return from, to, true
+}
+
+const (
+	chartWidth   = 640
+	chartHeight  = 320
+	chartPadding = 60
+)
+
+// chartSVG draws the rates of the currency. The x axis spans from and to, the y axis spans the minimum and maximum rate. The currency must be validated by the caller.
+func chartSVG(currency, from, to string, days []Day) string {
+	fromTime, _ := time.Parse("2006-01-02", from)
+	toTime, _ := time.Parse("2006-01-02", to)
+	span := max(toTime.Sub(fromTime).Hours(), 24)
+
+	type point struct {
+		t    time.Time
+		rate float64
+	}
+	var points []point
+	var minRate, maxRate = math.Inf(1), math.Inf(-1)
+	for _, day := range days {
+		rate, ok := day.Rates[currency]
+		if !ok {
+			continue
+		}
+		t, _ := time.Parse("2006-01-02", day.Date)
+		points = append(points, point{t, rate})
+		minRate = min(minRate, rate)
+		maxRate = max(maxRate, rate)
+	}
+
+	b := &strings.Builder{}
+	fmt.Fprintf(b, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" font-family="sans-serif" font-size="12">`, chartWidth, chartHeight, chartWidth, chartHeight)
+	fmt.Fprintf(b, `<title>%s per EUR, %s to %s</title>`, currency, from, to)
+	fmt.Fprintf(b, `<rect width="%d" height="%d" fill="white"/>`, chartWidth, chartHeight)
+	fmt.Fprintf(b, `<text x="%d" y="20" font-weight="bold">%s per EUR</text>`, chartPadding, currency)
+
+	var left, right, top, bottom = chartPadding, chartWidth - chartPadding/2, chartPadding / 2, chartHeight - chartPadding/2
+	fmt.Fprintf(b, `<path d="M%d %dV%dH%d" fill="none" stroke="gray"/>`, left, top, bottom, right)
+	fmt.Fprintf(b, `<text x="%d" y="%d">%s</text>`, left, bottom+16, from)
+	fmt.Fprintf(b, `<text x="%d" y="%d" text-anchor="end">%s</text>`, right, bottom+16, to)
+
+	if len(points) == 0 {
+		fmt.Fprintf(b, `<text x="%d" y="%d" text-anchor="middle">no data</text>`, (left+right)/2, (top+bottom)/2)
+	} else {
+		if maxRate-minRate < maxRate*1e-6 { // flat line
+			minRate, maxRate = minRate*0.99, maxRate*1.01
+		}
+		x := func(t time.Time) float64 {
+			return float64(left) + t.Sub(fromTime).Hours()/span*float64(right-left)
+		}
+		y := func(rate float64) float64 {
+			return float64(bottom) - (rate-minRate)/(maxRate-minRate)*float64(bottom-top)
+		}
+		fmt.Fprintf(b, `<text x="%d" y="%d" text-anchor="end">%s</text>`, left-4, top+4, strconv.FormatFloat(maxRate, 'g', 5, 64))
+		fmt.Fprintf(b, `<text x="%d" y="%d" text-anchor="end">%s</text>`, left-4, bottom, strconv.FormatFloat(minRate, 'g', 5, 64))
+		b.WriteString(`<polyline fill="none" stroke="#0d6efd" stroke-width="2" points="`)
+		for i, p := range points {
+			if i > 0 {
+				b.WriteString(" ")
+			}
+			fmt.Fprintf(b, "%.1f,%.1f", x(p.t), y(p.rate))
+		}
+		b.WriteString(`"/>`)
+	}
+	b.WriteString(`</svg>`)
+	return b.String()
+}
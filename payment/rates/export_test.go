@@ -0,0 +1,107 @@
+package rates
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func testExportHistory(t *testing.T) *History {
+	db, err := OpenDB(filepath.Join(t.TempDir(), "rates.sqlite3"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for date, rates := range map[string]map[string]float64{
+		"2024-05-07": {"USD": 1.0783},
+		"2024-05-08": {"USD": 1.0751, "CHF": 0.9758},
+		"2024-05-10": {"USD": 1.0772, "CHF": 0.9765},
+		"2024-05-13": {"USD": 1.0788},
+	} {
+		if err := db.Insert(date, rates); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := db.Override("2024-05-10", map[string]float64{"USD": 1.0773, "CHF": 0.9765}, "typo"); err != nil {
+		t.Fatal(err)
+	}
+	return &History{Database: db}
+}
+
+func TestExport(t *testing.T) {
+	h := testExportHistory(t)
+
+	buf := &bytes.Buffer{}
+	if err := h.ExportCSV(buf, "2024-05-08", "2024-05-12"); err != nil {
+		t.Fatal(err)
+	}
+	want := "date,version,CHF,USD\n2024-05-08,0,0.9758,1.0751\n2024-05-10,1,0.9765,1.0773\n"
+	if buf.String() != want {
+		t.Fatalf("got %q, want %q", buf, want)
+	}
+
+	buf.Reset()
+	if err := h.ExportJSON(buf, "2024-05-13", "2024-05-31"); err != nil {
+		t.Fatal(err)
+	}
+	var days []Day
+	if err := json.Unmarshal(buf.Bytes(), &days); err != nil {
+		t.Fatal(err)
+	}
+	if len(days) != 1 || days[0].Date != "2024-05-13" || days[0].Rates["USD"] != 1.0788 {
+		t.Fatalf("got %+v", days)
+	}
+
+	w := httptest.NewRecorder()
+	h.ExportHandler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/rates/export?format=json&from=2025-01-01&to=2025-01-31", nil))
+	if got := strings.TrimSpace(w.Body.String()); got != "[]" {
+		t.Fatalf("got %s, want []", got)
+	}
+
+	w = httptest.NewRecorder()
+	h.ExportHandler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/rates/export?format=csv&from=2024-05-31&to=2024-05-01", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("got status %d for reversed range, want 400", w.Code)
+	}
+
+	h.Database.sqldb.Close()
+	w = httptest.NewRecorder()
+	h.ExportHandler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/rates/export?format=csv", nil))
+	if w.Code != http.StatusInternalServerError || w.Header().Get("Content-Disposition") != "" {
+		t.Fatalf("got status %d and headers %v for database error, want 500", w.Code, w.Header())
+	}
+}
+
+func TestChartHandler(t *testing.T) {
+	h := testExportHistory(t)
+
+	w := httptest.NewRecorder()
+	h.ChartHandler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/rates/chart.svg?currency=USD&from=2024-05-07&to=2024-05-13", nil))
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "image/svg+xml" {
+		t.Fatalf("got %d %s", w.Code, w.Header().Get("Content-Type"))
+	}
+	body := w.Body.String()
+	for _, want := range []string{
+		"<svg ",
+		"USD per EUR",
+		`points="60.0,65.1 151.7,290.0 335.0,135.4 610.0,30.0"`,
+		">1.0788<",
+		">1.0751<",
+	} {
+		if !strings.Contains(body, want) {
+			t.Fatalf("%s not found in %s", want, body)
+		}
+	}
+	if strings.Contains(body, "<script") {
+		t.Fatal("chart contains script")
+	}
+
+	w = httptest.NewRecorder()
+	h.ChartHandler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/rates/chart.svg?currency=<b>", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("got status %d for invalid currency, want 400", w.Code)
+	}
+}
@@ -3,21 +3,73 @@ package rates
 
 import (
 	"cmp"
+	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
+	"math"
 	"math/rand"
 	"net/http"
 	"slices"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/dys2p/eco/lang"
+	"golang.org/x/text/number"
 )
 
+// History stores the daily buy rates. If there is more than one source, create it like this:
+//
+//	h := &rates.History{
+//		Database:  db,
+//		Sources:   []func() (map[string]float64, error){ecb.GetBuyRates, getBankRates, getExchangeOfficeRates},
+//		MaxChange: 5,
+//	}
+//	h.Run()
 type History struct {
 	Database    *SQLiteDB
 	GetBuyRates func() (map[string]float64, error)
-	Synced      bool // updated today or yesterday
+	Sources     []func() (map[string]float64, error) // optional, queried in addition to GetBuyRates, the median of all sources is used for each currency, days with a currency from a single source are flagged for approval
+	MaxChange   float64                              // percent, optional, days with a rate change above MaxChange are not inserted but flagged for approval, see SQLiteDB.Pending
+	Rules       map[string]Rule                      // optional, currencies which are not found here use DefaultRules
+	Synced      bool                                 // updated today or yesterday
+}
+
+// A Rule defines the margin and rounding of a currency. The zero value rounds up to 0.01.
+type Rule struct {
+	Step   float64 // smallest accepted amount, like 0.05 for CHF coins, 1 for JPY or 5 for whole notes of 5 USD and more; default: 0.01
+	Margin float64 // percent, added to the stored rate
+}
+
+// DefaultRules covers currencies whose smallest coin is not 0.01.
+var DefaultRules = map[string]Rule{
+	"CHF": {Step: 0.05},
+	"ISK": {Step: 1},
+	"JPY": {Step: 1},
+}
+
+func (h *History) rule(currency string) Rule {
+	if rule, ok := h.Rules[currency]; ok {
+		return rule
+	}
+	return DefaultRules[currency]
+}
+
+// round rounds value up to a multiple of step and returns it as an exact decimal with the decimal places of step.
+func round(value, step float64) string {
+	if step <= 0 {
+		step = 0.01
+	}
+	stepDecimal := strconv.FormatFloat(step, 'f', -1, 64)
+	var decimals int
+	if _, fraction, ok := strings.Cut(stepDecimal, "."); ok {
+		decimals = len(fraction)
+	}
+	n := math.Ceil(value/step - 1e-9) // tolerate floating point errors like 12.350000000000001 / 0.05
+	return strconv.FormatFloat(n*step, 'f', decimals, 64)
 }
 
 // MakeAndRun starts a goroutine which calls GetBuyRates every 45-60 minutes. If GetBuyRates returns rates, they are inserted into the database and GetBuyRates is not called until the next day.
@@ -31,65 +83,363 @@ func MakeAndRun(sqlitePath string, getBuyRates func() (map[string]float64, error
 		Database:    db,
 		GetBuyRates: getBuyRates,
 	}
+	h.Run()
+	return h, nil
+}
 
+// Run starts a goroutine which queries GetBuyRates and Sources every 45-60 minutes. Once the rates of the current day have been inserted into the database, they are not queried until the next day.
+func (h *History) Run() {
 	go func() {
 		for ; true; time.Sleep(time.Duration(45*int64(time.Minute) + rand.Int63n(15*int64(time.Minute)))) {
-			now := time.Now()
-			today := now.Format("2006-01-02")
-			yesterday := now.AddDate(0, 0, -1).Format("2006-01-02")
+			h.update(time.Now())
+		}
+	}()
+}
 
-			lastUpdateDate, err := h.Database.LatestDate(today)
-			if err != nil {
-				log.Printf("\033[31m"+"error getting latest date from database: %v"+"\033[0m", err)
-				h.Synced = false // database error means no good for sync status
-				continue
-			}
+// update queries the sources unless the rates of the current day are in the database already. It logs errors instead of returning them.
+func (h *History) update(now time.Time) {
+	today := now.Format("2006-01-02")
+	yesterday := now.AddDate(0, 0, -1).Format("2006-01-02")
 
-			h.Synced = lastUpdateDate == today || lastUpdateDate == yesterday
-			if lastUpdateDate == today {
-				continue // already updated today
-			}
+	lastUpdateDate, err := h.Database.LatestDate(today)
+	if err != nil {
+		log.Printf("\033[31m"+"error getting latest date from database: %v"+"\033[0m", err)
+		h.Synced = false // database error means no good for sync status
+		return
+	}
 
-			buyRates, err := h.GetBuyRates()
-			if err != nil {
-				log.Printf("\033[31m"+"error getting rates: %v"+"\033[0m", err)
+	h.Synced = lastUpdateDate == today || lastUpdateDate == yesterday
+	if lastUpdateDate == today {
+		return // already updated today
+	}
+
+	buyRates, flag, err := h.query()
+	if err != nil {
+		log.Printf("\033[31m"+"error getting rates: %v"+"\033[0m", err)
+		return
+	}
+	if len(buyRates) == 0 {
+		return // nothing to insert
+	}
+
+	reason, err := h.insert(today, lastUpdateDate, buyRates, flag)
+	switch {
+	case err != nil:
+		log.Printf("\033[31m"+"error updating rates: %v"+"\033[0m", err)
+	case reason != "":
+		log.Printf("\033[31m"+"foreign cash rates need approval: %s"+"\033[0m", reason)
+	default:
+		log.Println("\033[32m" + "updated foreign cash rates" + "\033[0m")
+	}
+}
+
+// Backfill inserts the rates of past days, like those returned by ECB.History. Dates which exist or await approval already are not modified.
+// Like in the daily update, days with a rate change above MaxChange are flagged for approval. If there are Sources, all backfilled days are flagged, because they could not be compared with the other sources.
+func (h *History) Backfill(days map[string]map[string]float64) error {
+	pending, err := h.Database.Pending()
+	if err != nil {
+		return fmt.Errorf("getting pending days: %w", err)
+	}
+
+	var dates []string
+	for date := range days {
+		dates = append(dates, date)
+	}
+	slices.Sort(dates) // oldest first, so each day is checked against the previous one
+
+	var flag string
+	if len(h.Sources) > 0 {
+		flag = "backfilled from a single source"
+	}
+
+	for _, date := range dates {
+		if slices.ContainsFunc(pending, func(p PendingDay) bool { return p.Date == date }) {
+			continue
+		}
+		switch _, err := h.Database.Get(date); {
+		case err == nil:
+			continue
+		case !errors.Is(err, sql.ErrNoRows):
+			return fmt.Errorf("getting rates of %s: %w", date, err)
+		}
+		t, err := time.Parse("2006-01-02", date)
+		if err != nil {
+			return fmt.Errorf("invalid date: %s", date)
+		}
+		prevDate, err := h.Database.LatestDate(t.AddDate(0, 0, -1).Format("2006-01-02"))
+		if err != nil {
+			return fmt.Errorf("getting latest date before %s: %w", date, err)
+		}
+		reason, err := h.insert(date, prevDate, days[date], flag)
+		if err != nil {
+			return fmt.Errorf("backfilling %s: %w", date, err)
+		}
+		if reason != "" {
+			log.Printf("\033[31m"+"backfilled foreign cash rates of %s need approval: %s"+"\033[0m", date, reason)
+		}
+	}
+	return nil
+}
+
+// insert inserts the rates of date into the database, or flags them for approval if flag is not empty or if check finds a rate change above MaxChange since prevDate. It returns the reason if the rates have been flagged.
+func (h *History) insert(date, prevDate string, rates map[string]float64, flag string) (string, error) {
+	reason, err := h.check(prevDate, rates)
+	if err != nil {
+		return "", fmt.Errorf("checking rates: %w", err)
+	}
+	if flag != "" {
+		reason = strings.Join(slices.DeleteFunc([]string{flag, reason}, func(s string) bool { return s == "" }), ", ")
+	}
+	if reason != "" {
+		if err := h.Database.InsertPending(date, rates, reason); err != nil {
+			return "", fmt.Errorf("inserting pending rates: %w", err)
+		}
+		return reason, nil
+	}
+
+	if err := h.Database.Insert(date, rates); err != nil {
+		return "", fmt.Errorf("inserting rates: %w", err)
+	}
+	if err := h.Database.Reject(date); err != nil { // rates flagged earlier are obsolete
+		log.Printf("\033[31m"+"error removing obsolete pending rates of %s: %v"+"\033[0m", date, err)
+	}
+	return "", nil
+}
+
+// query calls GetBuyRates and all Sources and returns the median rate of each currency. Failing sources are logged and skipped.
+// If there is more than one source, query also returns a flag which lists the currencies that only one source has returned, because their rates can't be checked against each other.
+func (h *History) query() (map[string]float64, string, error) {
+	var sources = h.Sources
+	if h.GetBuyRates != nil {
+		sources = append([]func() (map[string]float64, error){h.GetBuyRates}, sources...)
+	}
+
+	if len(sources) == 0 {
+		return nil, "", errors.New("no sources")
+	}
+
+	var all = make(map[string][]float64)
+	var errs []error
+	for i, source := range sources {
+		rates, err := source()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("source %d: %w", i, err))
+			continue
+		}
+		for currency, rate := range rates {
+			if rate <= 0 || math.IsInf(rate, 0) || math.IsNaN(rate) {
 				continue
 			}
-			if len(buyRates) == 0 {
-				continue // nothing to insert
-			}
-			if err := h.Database.Insert(today, buyRates); err == nil {
-				log.Println("\033[32m" + "updated foreign cash rates" + "\033[0m")
-			} else {
-				log.Printf("\033[31m"+"error inserting rates: %v"+"\033[0m", err)
-			}
+			all[currency] = append(all[currency], rate)
 		}
-	}()
+	}
+	if len(errs) == len(sources) {
+		return nil, "", errors.Join(errs...)
+	}
+	for _, err := range errs {
+		log.Printf("\033[31m"+"error getting rates from %v"+"\033[0m", err)
+	}
 
-	return h, nil
+	var result = make(map[string]float64)
+	var single []string
+	for currency, rates := range all {
+		result[currency] = median(rates)
+		if len(rates) < 2 {
+			single = append(single, currency)
+		}
+	}
+
+	var flag string
+	if len(sources) > 1 && len(single) > 0 {
+		slices.Sort(single)
+		flag = strings.Join(single, "/") + " from a single source"
+	}
+	return result, flag, nil
+}
+
+// check compares the rates to the rates of the given date. It returns a reason if a rate has changed by more than MaxChange.
+func (h *History) check(date string, rates map[string]float64) (string, error) {
+	if h.MaxChange <= 0 {
+		return "", nil
+	}
+	previous, err := h.Database.Get(date)
+	switch {
+	case err == nil:
+	case errors.Is(err, sql.ErrNoRows):
+		return "", nil // first day
+	default:
+		return "", err
+	}
+
+	var reasons []string
+	for currency, rate := range rates {
+		prev, ok := previous[currency]
+		if !ok || prev <= 0 {
+			continue
+		}
+		if change := (rate/prev - 1) * 100; math.Abs(change) > h.MaxChange {
+			reasons = append(reasons, fmt.Sprintf("%s changed by %+.1f%% since %s", currency, change, date))
+		}
+	}
+	slices.Sort(reasons)
+	return strings.Join(reasons, ", "), nil
+}
+
+func median(values []float64) float64 {
+	values = slices.Clone(values)
+	slices.Sort(values)
+	n := len(values)
+	if n%2 == 1 {
+		return values[n/2]
+	}
+	return (values[n/2-1] + values[n/2]) / 2
 }
 
 func (h *History) Options(effectiveDate string, value float64) ([]Option, error) {
+	quote, err := h.Quote(effectiveDate, value)
+	return quote.Options, err
+}
+
+// A Quote contains the currency options for a value, and the date and version of the rates which have been used.
+// Store Date and Version along with the purchase, so the options can be reproduced with SQLiteDB.GetVersion even if the rates are overridden later.
+type Quote struct {
+	Date    string
+	Version int
+	Options []Option
+}
+
+// Quote is like Options, but it reports the date and version of the rates.
+func (h *History) Quote(effectiveDate string, value float64) (Quote, error) {
 	date, err := h.Database.LatestDate(effectiveDate)
 	if err != nil {
-		return nil, fmt.Errorf("getting latest date: %w", err)
+		return Quote{}, fmt.Errorf("getting latest date: %w", err)
+	}
+	version, err := h.Database.Version(date)
+	if err != nil {
+		return Quote{}, fmt.Errorf("getting version of %s: %w", date, err)
 	}
 
-	rs, err := h.Database.Get(date)
+	rs, err := h.Database.GetVersion(date, version)
 	if err != nil {
-		return nil, fmt.Errorf("getting rates for %s from database: %w", date, err) // unlikely because we got the date from the database
+		return Quote{}, fmt.Errorf("getting rates for %s from database: %w", date, err) // unlikely because we got the date from the database
 	}
 	var options []Option
 	for currency, rate := range rs {
+		rule := h.rule(currency)
+		price := value * rate * (1 + rule.Margin/100.0)
 		options = append(options, Option{
 			Currency: currency,
-			Price:    value * rate,
+			Price:    price,
+			Amount:   round(price, rule.Step),
 		})
 	}
 	slices.SortFunc(options, func(a, b Option) int {
 		return cmp.Compare(a.Currency, b.Currency)
 	})
-	return options, nil
+	return Quote{
+		Date:    date,
+		Version: version,
+		Options: options,
+	}, nil
+}
+
+// AdminHandler returns a JSON API for correcting the rates of a day. It must be protected by authentication.
+//
+// GET ?date=yyyy-mm-dd returns the rates of the day, their version, the audit entries and the pending days.
+// POST with a JSON body like {"date": "2024-05-10", "rates": {"USD": 1.1}, "reason": "..."} overrides the rates of the day and returns the audit entry.
+// POST with a JSON body like {"date": "2024-05-10", "action": "approve"} or "reject" approves or rejects the pending rates of the day, see SQLiteDB.Approve and SQLiteDB.Reject.
+func (h *History) AdminHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			date := r.URL.Query().Get("date")
+			if _, err := time.Parse("2006-01-02", date); err != nil {
+				http.Error(w, "invalid date", http.StatusBadRequest)
+				return
+			}
+			var resp adminResponse
+			var err error
+			resp.Date = date
+			resp.Rates, err = h.Database.Get(date)
+			if err != nil && !errors.Is(err, sql.ErrNoRows) {
+				adminError(w, err)
+				return
+			}
+			if resp.Version, err = h.Database.Version(date); err != nil {
+				adminError(w, err)
+				return
+			}
+			if resp.Audit, err = h.Database.Audit(date); err != nil {
+				adminError(w, err)
+				return
+			}
+			if resp.Pending, err = h.Database.Pending(); err != nil {
+				adminError(w, err)
+				return
+			}
+			w.Header().Add("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(resp)
+		case http.MethodPost:
+			var req adminRequest
+			if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&req); err != nil {
+				http.Error(w, "invalid JSON", http.StatusBadRequest)
+				return
+			}
+			switch req.Action {
+			case "":
+			case "approve":
+				if err := h.Database.Approve(req.Date); err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+				log.Printf("approved pending foreign cash rates of %s", req.Date)
+				w.WriteHeader(http.StatusNoContent)
+				return
+			case "reject":
+				if err := h.Database.Reject(req.Date); err != nil {
+					adminError(w, err)
+					return
+				}
+				log.Printf("rejected pending foreign cash rates of %s", req.Date)
+				w.WriteHeader(http.StatusNoContent)
+				return
+			default:
+				http.Error(w, "invalid action", http.StatusBadRequest)
+				return
+			}
+			entry, err := h.Database.Override(req.Date, req.Rates, req.Reason)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			log.Printf("overrode foreign cash rates of %s: %s", entry.Date, entry.Reason)
+			w.Header().Add("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(entry)
+		default:
+			w.Header().Set("Allow", "GET, POST")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+}
+
+type adminRequest struct {
+	Action string             `json:"action"` // "approve", "reject" or empty for an override
+	Date   string             `json:"date"`
+	Rates  map[string]float64 `json:"rates"`
+	Reason string             `json:"reason"`
+}
+
+type adminResponse struct {
+	Date    string             `json:"date"`
+	Rates   map[string]float64 `json:"rates"` // null if the day is missing
+	Version int                `json:"version"`
+	Audit   []AuditEntry       `json:"audit"`
+	Pending []PendingDay       `json:"pending"`
+}
+
+func adminError(w http.ResponseWriter, err error) {
+	log.Printf("\033[31m"+"error in rates admin handler: %v"+"\033[0m", err)
+	http.Error(w, "internal server error", http.StatusInternalServerError)
 }
 
 // SyncedHandler writes JSON true or false. The returned handler can be queried extensively because it just reads a variable.
@@ -101,8 +451,19 @@ func (h *History) SyncedHandler() http.HandlerFunc {
 }
 
 type Option struct {
-	Currency string // from GetBuyRates
-	Price    float64
+	Currency string  // from GetBuyRates
+	Price    float64 // unrounded, including the margin of the Rule
+	Amount   string  // exact decimal, Price rounded up according to the Rule, like "12.35" or "1500"
+}
+
+// Display formats Amount for the given language, like "1,500.50" in English or "1.500,50" in German.
+func (opt Option) Display(l lang.Lang) string {
+	var decimals int
+	if _, fraction, ok := strings.Cut(opt.Amount, "."); ok {
+		decimals = len(fraction)
+	}
+	value, _ := strconv.ParseFloat(opt.Amount, 64)
+	return l.Printer.Sprint(number.Decimal(value, number.Scale(decimals)))
 }
 
 // ISO 4217
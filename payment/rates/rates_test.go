@@ -1,9 +1,21 @@
 package rates
 
 import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"maps"
 	"math"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
+
+	"github.com/dys2p/eco/lang"
+	"golang.org/x/text/language"
+	"golang.org/x/text/message"
 )
 
 func TestRates(t *testing.T) {
@@ -25,8 +37,8 @@ func TestRates(t *testing.T) {
 	}
 
 	want := []Option{
-		{"GBP", 85},
-		{"USD", 110},
+		{Currency: "GBP", Price: 85},
+		{Currency: "USD", Price: 110},
 	}
 	for i := range got {
 		if got[i].Currency != want[i].Currency {
@@ -38,3 +50,227 @@ func TestRates(t *testing.T) {
 		}
 	}
 }
+
+func source(rates map[string]float64) func() (map[string]float64, error) {
+	return func() (map[string]float64, error) {
+		return rates, nil
+	}
+}
+
+func TestSources(t *testing.T) {
+	db, err := OpenDB(filepath.Join(t.TempDir(), "rates.sqlite3"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Insert("2024-05-09", map[string]float64{"CHF": 1.0, "USD": 1.1}); err != nil {
+		t.Fatal(err)
+	}
+
+	h := &History{
+		Database: db,
+		Sources: []func() (map[string]float64, error){
+			source(map[string]float64{"CHF": 1.01, "USD": 1.1}),
+			source(map[string]float64{"CHF": 1.02, "USD": 5.0, "GBP": 0.9}), // bad USD rate
+			source(map[string]float64{"CHF": 1.03, "USD": 1.2}),
+			func() (map[string]float64, error) { return nil, errors.New("unavailable") },
+		},
+		MaxChange: 5,
+	}
+
+	// median of 1.1, 1.2 and 5.0 is an increase of more than 5%
+	h.update(time.Date(2024, 5, 10, 12, 0, 0, 0, time.UTC))
+	if _, err := db.Get("2024-05-10"); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("got %v, want ErrNoRows", err)
+	}
+	pending, err := db.Pending()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 1 || pending[0].Date != "2024-05-10" || pending[0].Reason != "GBP from a single source, USD changed by +9.1% since 2024-05-09" || pending[0].Rates["CHF"] != 1.02 || pending[0].Rates["GBP"] != 0.9 {
+		t.Fatalf("got pending %+v", pending)
+	}
+	if h.Synced != true {
+		t.Fatal("got not synced")
+	}
+
+	if err := db.Approve("2024-05-10"); err != nil {
+		t.Fatal(err)
+	}
+	if rates, err := db.Get("2024-05-10"); err != nil || rates["USD"] != 1.2 {
+		t.Fatalf("got %v, %v", rates, err)
+	}
+	if pending, _ := db.Pending(); len(pending) != 0 {
+		t.Fatalf("got pending %+v after approval", pending)
+	}
+
+	// rates within MaxChange are inserted
+	h.Sources = h.Sources[2:3]
+	h.update(time.Date(2024, 5, 11, 12, 0, 0, 0, time.UTC))
+	if rates, err := db.Get("2024-05-11"); err != nil || rates["USD"] != 1.2 {
+		t.Fatalf("got %v, %v", rates, err)
+	}
+
+	// all sources fail
+	h.Sources = h.Sources[:0]
+	if _, _, err := h.query(); err == nil {
+		t.Fatal("got no error without sources")
+	}
+}
+
+func TestMedian(t *testing.T) {
+	tests := []struct {
+		values []float64
+		want   float64
+	}{
+		{[]float64{3}, 3},
+		{[]float64{3, 1}, 2},
+		{[]float64{5, 1, 3}, 3},
+		{[]float64{4, 1, 100, 2}, 3},
+	}
+	for _, test := range tests {
+		if got := median(test.values); got != test.want {
+			t.Fatalf("median(%v): got %f, want %f", test.values, got, test.want)
+		}
+	}
+}
+
+func TestOverride(t *testing.T) {
+	db, err := OpenDB(filepath.Join(t.TempDir(), "rates.sqlite3"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Insert("2024-05-10", map[string]float64{"USD": 11}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.InsertPending("2024-05-11", map[string]float64{"USD": 1.2}, "USD changed"); err != nil {
+		t.Fatal(err)
+	}
+	h := &History{Database: db}
+
+	quote, err := h.Quote("2024-05-12", 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if quote.Date != "2024-05-10" || quote.Version != 0 || len(quote.Options) != 1 || quote.Options[0].Price != 1100 {
+		t.Fatalf("got quote %+v", quote)
+	}
+
+	if _, err := db.Override("2024-05-10", map[string]float64{"USD": 1.1}, ""); err == nil {
+		t.Fatal("got no error for missing reason")
+	}
+
+	handler := h.AdminHandler()
+	r := httptest.NewRequest(http.MethodPost, "/admin/rates", strings.NewReader(`{"date": "2024-05-10", "rates": {"USD": 1.1}, "reason": "typo"}`))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("got %d %s", w.Code, w.Body)
+	}
+	if _, err := db.Override("2024-05-11", map[string]float64{"USD": 1.15}, "approved with correction"); err != nil {
+		t.Fatal(err)
+	}
+
+	quote, err = h.Quote("2024-05-10", 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if quote.Version != 1 || math.Abs(quote.Options[0].Price-110) > 0.001 {
+		t.Fatalf("got quote %+v", quote)
+	}
+
+	// reproduce the original quote
+	if rates, err := db.GetVersion("2024-05-10", 0); err != nil || rates["USD"] != 11 {
+		t.Fatalf("got version 0: %v, %v", rates, err)
+	}
+	if rates, err := db.GetVersion("2024-05-10", 1); err != nil || rates["USD"] != 1.1 {
+		t.Fatalf("got version 1: %v, %v", rates, err)
+	}
+	if _, err := db.GetVersion("2024-05-11", 0); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("got %v, want ErrNoRows for the day which was missing", err)
+	}
+
+	r = httptest.NewRequest(http.MethodGet, "/admin/rates?date=2024-05-11", nil)
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	var resp adminResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Version != 1 || resp.Rates["USD"] != 1.15 || len(resp.Audit) != 1 || resp.Audit[0].OldRates != nil || resp.Audit[0].Reason != "approved with correction" || len(resp.Pending) != 0 {
+		t.Fatalf("got %+v", resp)
+	}
+}
+
+func TestRules(t *testing.T) {
+	db, err := OpenDB(filepath.Join(t.TempDir(), "rates.sqlite3"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Insert("2024-05-10", map[string]float64{"CHF": 0.9765, "JPY": 167.81, "PLN": 4.2968, "USD": 1.0772}); err != nil {
+		t.Fatal(err)
+	}
+	h := &History{
+		Database: db,
+		Rules: map[string]Rule{
+			"PLN": {Margin: 2},
+			"USD": {Step: 5}, // whole notes
+		},
+	}
+
+	options, err := h.Options("2024-05-10", 12.34)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make(map[string]string)
+	for _, option := range options {
+		got[option.Currency] = option.Amount
+	}
+	want := map[string]string{
+		"CHF": "12.10", // 12.05001
+		"JPY": "2071",  // 2070.7754
+		"PLN": "54.09", // 54.0815 with margin
+		"USD": "15",    // 13.292648
+	}
+	if !maps.Equal(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+}
+
+func TestRound(t *testing.T) {
+	tests := []struct {
+		value float64
+		step  float64
+		want  string
+	}{
+		{12.35, 0.05, "12.35"},
+		{12.351, 0.05, "12.40"},
+		{12.3, 0, "12.30"},
+		{12.301, 0.01, "12.31"},
+		{0.1 + 0.2, 0.1, "0.3"},
+		{1500.2, 1, "1501"},
+		{41, 20, "60"},
+	}
+	for _, test := range tests {
+		if got := round(test.value, test.step); got != test.want {
+			t.Fatalf("round(%f, %f): got %s, want %s", test.value, test.step, got, test.want)
+		}
+	}
+}
+
+func TestDisplay(t *testing.T) {
+	tests := []struct {
+		tag    language.Tag
+		amount string
+		want   string
+	}{
+		{language.English, "1500", "1,500"},
+		{language.English, "1234.50", "1,234.50"},
+		{language.German, "1234.50", "1.234,50"},
+	}
+	for _, test := range tests {
+		l := lang.Lang{Printer: message.NewPrinter(test.tag)}
+		if got := (Option{Amount: test.amount}).Display(l); got != test.want {
+			t.Fatalf("%s %s: got %s, want %s", test.tag, test.amount, got, test.want)
+		}
+	}
+}
@@ -3,16 +3,52 @@ package rates
 import (
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"time"
 
 	_ "github.com/mattn/go-sqlite3"
 )
 
 type SQLiteDB struct {
-	sqldb  *sql.DB
-	get    *sql.Stmt
-	insert *sql.Stmt
-	latest *sql.Stmt
+	sqldb         *sql.DB
+	audit         *sql.Stmt
+	auditVersion  *sql.Stmt
+	between       *sql.Stmt
+	deletePending *sql.Stmt
+	get           *sql.Stmt
+	getPending    *sql.Stmt
+	insert        *sql.Stmt
+	insertAudit   *sql.Stmt
+	insertPending *sql.Stmt
+	latest        *sql.Stmt
+	pending       *sql.Stmt
+	replace       *sql.Stmt
+	version       *sql.Stmt
+}
+
+// A Day contains the rates of a date and their version, see SQLiteDB.Version.
+type Day struct {
+	Date    string             `json:"date"`
+	Version int                `json:"version"`
+	Rates   map[string]float64 `json:"rates"`
+}
+
+// An AuditEntry records an override of the rates of a day.
+type AuditEntry struct {
+	Date     string             `json:"date"`
+	Version  int                `json:"version"`   // version of NewRates, the original rates are version 0
+	OldRates map[string]float64 `json:"old-rates"` // nil if the day was missing
+	NewRates map[string]float64 `json:"new-rates"`
+	Reason   string             `json:"reason"`
+	Time     time.Time          `json:"time"`
+}
+
+// A PendingDay contains rates which have been flagged by History.MaxChange and await manual approval.
+type PendingDay struct {
+	Date   string             `json:"date"`
+	Rates  map[string]float64 `json:"rates"`
+	Reason string             `json:"reason"`
 }
 
 func OpenDB(fpath string) (*SQLiteDB, error) {
@@ -27,28 +63,92 @@ func OpenDB(fpath string) (*SQLiteDB, error) {
 			rates text not null -- json map
 		);
 		create index if not exists date_index on rates_history (date);
+		create table if not exists rates_pending (
+			date   text primary key,
+			rates  text not null, -- json map
+			reason text not null
+		);
+		create table if not exists rates_audit (
+			date      text    not null,
+			version   integer not null,
+			old_rates text    not null, -- json map or null
+			new_rates text    not null, -- json map
+			reason    text    not null,
+			time      integer not null, -- unix timestamp
+			primary key (date, version)
+		);
 	`); err != nil {
 		return nil, err
 	}
 
+	audit, err := sqldb.Prepare("select date, version, old_rates, new_rates, reason, time from rates_audit where date = ? order by version")
+	if err != nil {
+		return nil, err
+	}
+	auditVersion, err := sqldb.Prepare("select old_rates, new_rates from rates_audit where date = ? and version = ?")
+	if err != nil {
+		return nil, err
+	}
+	between, err := sqldb.Prepare("select date, ifnull((select max(version) from rates_audit where rates_audit.date = rates_history.date), 0), rates from rates_history where date >= ? and date <= ? order by date")
+	if err != nil {
+		return nil, err
+	}
+	deletePending, err := sqldb.Prepare("delete from rates_pending where date = ?")
+	if err != nil {
+		return nil, err
+	}
 	get, err := sqldb.Prepare("select rates from rates_history where date = ?")
 	if err != nil {
 		return nil, err
 	}
+	getPending, err := sqldb.Prepare("select rates from rates_pending where date = ?")
+	if err != nil {
+		return nil, err
+	}
 	insert, err := sqldb.Prepare("insert or ignore into rates_history (date, rates) values (?, ?)") // ignore existing, don't modify them
 	if err != nil {
 		return nil, err
 	}
+	insertAudit, err := sqldb.Prepare("insert into rates_audit (date, version, old_rates, new_rates, reason, time) values (?, ?, ?, ?, ?, ?)")
+	if err != nil {
+		return nil, err
+	}
+	insertPending, err := sqldb.Prepare("insert or replace into rates_pending (date, rates, reason) values (?, ?, ?)") // the latest query replaces earlier ones
+	if err != nil {
+		return nil, err
+	}
 	latest, err := sqldb.Prepare("select ifnull(max(date), '0000-00-00') from rates_history where date <= ?")
 	if err != nil {
 		return nil, err
 	}
+	pending, err := sqldb.Prepare("select date, rates, reason from rates_pending order by date")
+	if err != nil {
+		return nil, err
+	}
+	replace, err := sqldb.Prepare("insert or replace into rates_history (date, rates) values (?, ?)")
+	if err != nil {
+		return nil, err
+	}
+	version, err := sqldb.Prepare("select ifnull(max(version), 0) from rates_audit where date = ?")
+	if err != nil {
+		return nil, err
+	}
 
 	return &SQLiteDB{
-		sqldb:  sqldb,
-		get:    get,
-		insert: insert,
-		latest: latest,
+		sqldb:         sqldb,
+		audit:         audit,
+		auditVersion:  auditVersion,
+		between:       between,
+		deletePending: deletePending,
+		get:           get,
+		getPending:    getPending,
+		insert:        insert,
+		insertAudit:   insertAudit,
+		insertPending: insertPending,
+		latest:        latest,
+		pending:       pending,
+		replace:       replace,
+		version:       version,
 	}, nil
 }
 
@@ -78,3 +178,219 @@ func (db *SQLiteDB) LatestDate(maxDate string) (string, error) {
 	var latest string
 	return latest, db.latest.QueryRow(maxDate).Scan(&latest)
 }
+
+func (db *SQLiteDB) InsertPending(date string, m map[string]float64, reason string) error {
+	encoded, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	_, err = db.insertPending.Exec(date, encoded, reason)
+	return err
+}
+
+// Pending returns the days which await approval, ordered by date.
+func (db *SQLiteDB) Pending() ([]PendingDay, error) {
+	rows, err := db.pending.Query()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var days []PendingDay
+	for rows.Next() {
+		var day PendingDay
+		var encoded []byte
+		if err := rows.Scan(&day.Date, &encoded, &day.Reason); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(encoded, &day.Rates); err != nil {
+			return nil, err
+		}
+		days = append(days, day)
+	}
+	return days, rows.Err()
+}
+
+// Approve moves the pending rates of the given date into the history. It returns an error if the history contains that date already.
+func (db *SQLiteDB) Approve(date string) error {
+	tx, err := db.sqldb.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var encoded []byte
+	if err := tx.Stmt(db.getPending).QueryRow(date).Scan(&encoded); err != nil {
+		return fmt.Errorf("getting pending rates of %s: %w", date, err)
+	}
+	result, err := tx.Stmt(db.insert).Exec(date, encoded)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return fmt.Errorf("rates of %s exist already", date)
+	}
+	if _, err := tx.Stmt(db.deletePending).Exec(date); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Reject removes the pending rates of the given date.
+func (db *SQLiteDB) Reject(date string) error {
+	_, err := db.deletePending.Exec(date)
+	return err
+}
+
+// Version returns the version of the rates of the given date. It is zero unless the rates have been overridden.
+func (db *SQLiteDB) Version(date string) (int, error) {
+	var version int
+	return version, db.version.QueryRow(date).Scan(&version)
+}
+
+// GetVersion returns the given version of the rates of a date. It returns ErrNoRows if no data is found.
+func (db *SQLiteDB) GetVersion(date string, version int) (map[string]float64, error) {
+	current, err := db.Version(date)
+	if err != nil {
+		return nil, err
+	}
+	if version == current {
+		return db.Get(date)
+	}
+
+	var oldRates, newRates []byte
+	switch {
+	case version == 0:
+		err = db.auditVersion.QueryRow(date, 1).Scan(&oldRates, &newRates)
+	case version > 0:
+		err = db.auditVersion.QueryRow(date, version).Scan(&oldRates, &newRates)
+		oldRates = newRates
+	default:
+		return nil, sql.ErrNoRows
+	}
+	if err != nil {
+		return nil, err
+	}
+	var rs map[string]float64
+	if err := json.Unmarshal(oldRates, &rs); err != nil {
+		return nil, err
+	}
+	if rs == nil {
+		return nil, sql.ErrNoRows // the day was missing
+	}
+	return rs, nil
+}
+
+// Override replaces the rates of the given date, or inserts them if the date is missing. The old rates are kept in the audit table, pending rates of the date are removed.
+func (db *SQLiteDB) Override(date string, m map[string]float64, reason string) (AuditEntry, error) {
+	if _, err := time.Parse("2006-01-02", date); err != nil {
+		return AuditEntry{}, fmt.Errorf("invalid date: %w", err)
+	}
+	if len(m) == 0 {
+		return AuditEntry{}, errors.New("no rates")
+	}
+	for currency, rate := range m {
+		if rate <= 0 {
+			return AuditEntry{}, fmt.Errorf("invalid %s rate: %f", currency, rate)
+		}
+	}
+	if reason == "" {
+		return AuditEntry{}, errors.New("missing reason")
+	}
+
+	tx, err := db.sqldb.Begin()
+	if err != nil {
+		return AuditEntry{}, err
+	}
+	defer tx.Rollback()
+
+	entry := AuditEntry{
+		Date:     date,
+		NewRates: m,
+		Reason:   reason,
+		Time:     time.Now(),
+	}
+
+	var oldEncoded = []byte("null")
+	switch err := tx.Stmt(db.get).QueryRow(date).Scan(&oldEncoded); err {
+	case nil:
+		if err := json.Unmarshal(oldEncoded, &entry.OldRates); err != nil {
+			return AuditEntry{}, err
+		}
+	case sql.ErrNoRows:
+	default:
+		return AuditEntry{}, err
+	}
+	if err := tx.Stmt(db.version).QueryRow(date).Scan(&entry.Version); err != nil {
+		return AuditEntry{}, err
+	}
+	entry.Version++
+
+	newEncoded, err := json.Marshal(m)
+	if err != nil {
+		return AuditEntry{}, err
+	}
+	if _, err := tx.Stmt(db.insertAudit).Exec(date, entry.Version, oldEncoded, newEncoded, reason, entry.Time.Unix()); err != nil {
+		return AuditEntry{}, err
+	}
+	if _, err := tx.Stmt(db.replace).Exec(date, newEncoded); err != nil {
+		return AuditEntry{}, err
+	}
+	if _, err := tx.Stmt(db.deletePending).Exec(date); err != nil {
+		return AuditEntry{}, err
+	}
+	return entry, tx.Commit()
+}
+
+// Audit returns the overrides of the given date, ordered by version.
+func (db *SQLiteDB) Audit(date string) ([]AuditEntry, error) {
+	rows, err := db.audit.Query(date)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []AuditEntry
+	for rows.Next() {
+		var entry AuditEntry
+		var oldEncoded, newEncoded []byte
+		var unix int64
+		if err := rows.Scan(&entry.Date, &entry.Version, &oldEncoded, &newEncoded, &entry.Reason, &unix); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(oldEncoded, &entry.OldRates); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(newEncoded, &entry.NewRates); err != nil {
+			return nil, err
+		}
+		entry.Time = time.Unix(unix, 0)
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+// Range returns the days from and to the given dates, inclusive, ordered by date. Days without rates are omitted.
+func (db *SQLiteDB) Range(from, to string) ([]Day, error) {
+	rows, err := db.between.Query(from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var days []Day
+	for rows.Next() {
+		var day Day
+		var encoded []byte
+		if err := rows.Scan(&day.Date, &day.Version, &encoded); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(encoded, &day.Rates); err != nil {
+			return nil, err
+		}
+		days = append(days, day)
+	}
+	return days, rows.Err()
+}
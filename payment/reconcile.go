@@ -0,0 +1,151 @@
+package payment
+
+import (
+	"fmt"
+	"log"
+)
+
+type Balance string
+
+const (
+	Unpaid    Balance = "unpaid"    // no payments, or all payments have been refunded
+	Underpaid Balance = "underpaid" // awaiting the remainder
+	Paid      Balance = "paid"      // paid within the tolerances
+	Overpaid  Balance = "overpaid"  // the difference should be refunded
+)
+
+// A Tolerance is the larger of an absolute and a relative amount.
+type Tolerance struct {
+	Cents   int
+	Percent float64 // of the purchase sum
+}
+
+func (t Tolerance) of(dueCents int) int {
+	return max(t.Cents, int(float64(dueCents)*t.Percent/100.0))
+}
+
+// A Reconciliation compares the payments of a purchase to its sum.
+type Reconciliation struct {
+	PurchaseID string
+	PaymentKey string
+	Balance    Balance
+	DueCents   int  // from PurchaseSum, in the minor unit of the purchase currency
+	PaidCents  int  // settled minus refunded
+	DiffCents  int  // PaidCents minus DueCents, negative if underpaid
+	Late       bool // at least one payment has been paid late
+}
+
+// A Reconciler adds up the settled and refunded payments of a purchase, as recorded in the Ledger, and compares them to the purchase sum.
+//
+// Use Wrap in order to reconcile a purchase after each payment or refund:
+//
+//	reconciler := payment.Reconciler{
+//		Ledger:       ledger,
+//		Purchases:    repo,
+//		Underpayment: payment.Tolerance{Percent: 1},
+//		NeedsRefund:  func(rec payment.Reconciliation) { ... },
+//	}
+//	method := payment.BTCPay{Purchases: reconciler.Wrap(repo)}
+type Reconciler struct {
+	Ledger       *Ledger
+	Purchases    PurchaseRepo // provides the purchase sum
+	Underpayment Tolerance    // underpayments within the tolerance count as paid
+	Overpayment  Tolerance    // overpayments within the tolerance count as paid
+
+	AwaitingRemainder func(rec Reconciliation) // optional, called if a payment or refund leaves the purchase underpaid
+	NeedsRefund       func(rec Reconciliation) // optional, called if a payment or refund leaves the purchase overpaid
+}
+
+// Reconcile sums up the ledger events of the purchase and classifies the result.
+func (r Reconciler) Reconcile(purchaseID, paymentKey string) (Reconciliation, error) {
+	rec := Reconciliation{
+		PurchaseID: purchaseID,
+		PaymentKey: paymentKey,
+	}
+	due, err := PurchaseSum(r.Purchases, purchaseID, paymentKey)
+	if err != nil {
+		return rec, fmt.Errorf("getting purchase sum: %w", err)
+	}
+	dueCents := due.Minor
+	rec.DueCents = dueCents
+
+	events, err := r.Ledger.Events(purchaseID)
+	if err != nil {
+		return rec, fmt.Errorf("getting ledger events: %w", err)
+	}
+	var payments int
+	for _, event := range events {
+		if event.PaymentKey != paymentKey {
+			continue
+		}
+		switch event.Type {
+		case EventSettled:
+			payments++
+			rec.PaidCents += event.Cents
+			rec.Late = rec.Late || event.PaidLate
+		case EventRefunded:
+			rec.PaidCents -= event.Cents
+		}
+	}
+	rec.DiffCents = rec.PaidCents - rec.DueCents
+
+	switch {
+	case payments == 0 || rec.PaidCents <= 0:
+		rec.Balance = Unpaid
+	case rec.DiffCents < -r.Underpayment.of(dueCents):
+		rec.Balance = Underpaid
+	case rec.DiffCents > r.Overpayment.of(dueCents):
+		rec.Balance = Overpaid
+	default:
+		rec.Balance = Paid
+	}
+	return rec, nil
+}
+
+// Wrap returns a PurchaseRepo which records events in the Ledger, passes them to repo only once and reconciles the purchase after each new payment or refund.
+func (r Reconciler) Wrap(repo PurchaseRepo) PurchaseRepo {
+	return r.Ledger.Wrap(reconcilerRepo{
+		PurchaseRepo: repo,
+		reconciler:   r,
+	})
+}
+
+type reconcilerRepo struct {
+	PurchaseRepo
+	reconciler Reconciler
+}
+
+func (rr reconcilerRepo) PurchaseSum(purchaseID, paymentKey string) (Amount, error) {
+	return PurchaseSum(rr.PurchaseRepo, purchaseID, paymentKey)
+}
+
+func (rr reconcilerRepo) PaymentRefunded(purchaseID, paymentKey, methodName, paymentID, refundID string, refundCents int) error {
+	if err := rr.PurchaseRepo.PaymentRefunded(purchaseID, paymentKey, methodName, paymentID, refundID, refundCents); err != nil {
+		return err
+	}
+	rr.notify(purchaseID, paymentKey)
+	return nil
+}
+
+func (rr reconcilerRepo) PaymentSettled(purchaseID, paymentKey, methodName, paymentID string, paymentCents int, paidLate bool) error {
+	if err := rr.PurchaseRepo.PaymentSettled(purchaseID, paymentKey, methodName, paymentID, paymentCents, paidLate); err != nil {
+		return err
+	}
+	rr.notify(purchaseID, paymentKey)
+	return nil
+}
+
+// notify does not return errors because the event has already been passed to the PurchaseRepo.
+func (rr reconcilerRepo) notify(purchaseID, paymentKey string) {
+	rec, err := rr.reconciler.Reconcile(purchaseID, paymentKey)
+	if err != nil {
+		log.Printf("[%s] error reconciling payments: %v", purchaseID+":"+paymentKey, err)
+		return
+	}
+	switch {
+	case rec.Balance == Underpaid && rr.reconciler.AwaitingRemainder != nil:
+		rr.reconciler.AwaitingRemainder(rec)
+	case rec.Balance == Overpaid && rr.reconciler.NeedsRefund != nil:
+		rr.reconciler.NeedsRefund(rec)
+	}
+}
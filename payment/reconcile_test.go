@@ -0,0 +1,59 @@
+package payment
+
+import (
+	"fmt"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestReconciler(t *testing.T) {
+	ledger, err := OpenLedger(filepath.Join(t.TempDir(), "ledger.sqlite3"))
+	if err != nil {
+		t.Fatalf("opening ledger: %v", err)
+	}
+	repo := &testRepo{sumCents: 1000}
+	var notifications []string
+	reconciler := Reconciler{
+		Ledger:       ledger,
+		Purchases:    repo,
+		Underpayment: Tolerance{Percent: 1}, // 10 cents
+		Overpayment:  Tolerance{Cents: 5},
+		AwaitingRemainder: func(rec Reconciliation) {
+			notifications = append(notifications, fmt.Sprintf("remainder %s %d", rec.PurchaseID, -rec.DiffCents))
+		},
+		NeedsRefund: func(rec Reconciliation) {
+			notifications = append(notifications, fmt.Sprintf("refund %s %d %t", rec.PurchaseID, rec.DiffCents, rec.Late))
+		},
+	}
+	wrapped := reconciler.Wrap(repo)
+
+	tests := []struct {
+		do          func()
+		wantBalance Balance
+		wantNotify  []string
+	}{
+		{func() {}, Unpaid, nil},
+		{func() { wrapped.PaymentSettled("ABC", "key", "SEPA", "tx-1", 600, false) }, Underpaid, []string{"remainder ABC 400"}},
+		{func() { wrapped.PaymentSettled("ABC", "key", "SEPA", "tx-1", 600, false) }, Underpaid, nil}, // replay
+		{func() { wrapped.PaymentSettled("ABC", "key", "BTCPay", "btc-1", 395, false) }, Paid, nil},   // within underpayment tolerance
+		{func() { wrapped.PaymentSettled("ABC", "key", "BTCPay", "btc-2", 300, true) }, Overpaid, []string{"refund ABC 295 true"}},
+		{func() { wrapped.PaymentSettled("ABC", "other", "BTCPay", "btc-3", 300, false) }, Overpaid, []string{"remainder ABC 700"}}, // other payment key
+		{func() { wrapped.PaymentRefunded("ABC", "key", "BTCPay", "btc-2", "refund-1", 292) }, Paid, nil},                           // within overpayment tolerance
+		{func() { wrapped.PaymentRefunded("ABC", "key", "SEPA", "tx-1", "refund-2", 1003) }, Unpaid, nil},
+	}
+	for i, test := range tests {
+		notifications = nil
+		test.do()
+		rec, err := reconciler.Reconcile("ABC", "key")
+		if err != nil {
+			t.Fatalf("test %d: reconciling: %v", i, err)
+		}
+		if rec.Balance != test.wantBalance {
+			t.Fatalf("test %d: got balance %s, want %s", i, rec.Balance, test.wantBalance)
+		}
+		if !slices.Equal(notifications, test.wantNotify) {
+			t.Fatalf("test %d: got notifications %q, want %q", i, notifications, test.wantNotify)
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package payment
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/dys2p/go-btcpay"
+	"github.com/dys2p/go-paypal"
+)
+
+func TestPayPalRefund(t *testing.T) {
+	var gotAmounts []*paypalAmount
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /v1/oauth2/token", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"access_token": "test-token"}`))
+	})
+	mux.HandleFunc("POST /v2/payments/captures/CAPTURE-1/refund", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer test-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		var req paypalRefundRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		gotAmounts = append(gotAmounts, req.Amount)
+		amount := paypalAmount{CurrencyCode: "EUR", Value: "20.00"} // full amount
+		if req.Amount != nil {
+			amount = *req.Amount
+		}
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(paypalRefundResponse{ID: "REFUND-1", Status: "COMPLETED", Amount: amount})
+	})
+	mux.HandleFunc("POST /v2/payments/captures/CAPTURE-3/refund", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(paypalRefundResponse{ID: "REFUND-3", Status: "PENDING", Amount: paypalAmount{CurrencyCode: "EUR", Value: "20.00"}})
+	})
+	mux.HandleFunc("POST /v2/payments/captures/CAPTURE-4/refund", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(paypalRefundResponse{ID: "REFUND-4", Status: "FAILED", Amount: paypalAmount{CurrencyCode: "EUR", Value: "20.00"}})
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	repo := &testRepo{}
+	p := PayPal{
+		Config: &paypal.Config{
+			OAuthAPI: srv.URL + "/v1/oauth2/token",
+			OrderAPI: srv.URL + "/v2/checkout/orders",
+		},
+		Purchases: repo,
+	}
+
+	refund, err := p.Refund("ABC", "key", "CAPTURE-1", 550)
+	if err != nil {
+		t.Fatalf("partial refund: %v", err)
+	}
+	if refund.ID != "REFUND-1" || refund.Cents != 550 {
+		t.Fatalf("got %+v", refund)
+	}
+	refund, err = p.Refund("ABC", "key", "CAPTURE-1", 0)
+	if err != nil {
+		t.Fatalf("full refund: %v", err)
+	}
+	if refund.Cents != 2000 {
+		t.Fatalf("got %d cents, want 2000", refund.Cents)
+	}
+	if len(gotAmounts) != 2 || gotAmounts[0].Value != "5.50" || gotAmounts[1] != nil {
+		t.Fatalf("got request amounts %v", gotAmounts)
+	}
+	if _, err := p.Refund("ABC", "key", "CAPTURE-2", 0); err == nil {
+		t.Fatalf("refunding unknown capture: got nil error")
+	}
+	repo.check(t,
+		"refunded ABC:key PayPal CAPTURE-1 REFUND-1 550",
+		"refunded ABC:key PayPal CAPTURE-1 REFUND-1 2000",
+	)
+
+	// pending refunds are reported by the webhook, failed refunds are not reported
+	if refund, err := p.Refund("ABC", "key", "CAPTURE-3", 0); err != nil || refund.ID != "REFUND-3" {
+		t.Fatalf("pending refund: got %+v, %v", refund, err)
+	}
+	if _, err := p.Refund("ABC", "key", "CAPTURE-4", 0); err == nil {
+		t.Fatalf("failed refund: got nil error")
+	}
+	repo.check(t)
+
+	// purchase currency
+	p.Purchases = chfRepo{repo}
+	if _, err := p.Refund("ABC", "key", "CAPTURE-1", 550); err != nil {
+		t.Fatalf("partial CHF refund: %v", err)
+	}
+	if len(gotAmounts) != 3 || gotAmounts[2].CurrencyCode != "CHF" || gotAmounts[2].Value != "5.50" {
+		t.Fatalf("got request amounts %v", gotAmounts)
+	}
+	repo.check(t, "refunded ABC:key PayPal CAPTURE-1 REFUND-1 550")
+}
+
+func TestBTCPayRefund(t *testing.T) {
+	var gotRefund btcpayRefundRequest
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v1/stores/store-1/invoices", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "token api-key" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if orderIDs := r.URL.Query()["orderId"]; len(orderIDs) != 2 || orderIDs[0] != "ABC:key" || orderIDs[1] != "ABC:key:lightning" {
+			w.Write([]byte(`[]`))
+			return
+		}
+		w.Write([]byte(`[{"id": "invoice-1", "metadata": {"orderId": "ABC:key"}}, {"id": "invoice-2", "metadata": {"orderId": "ABC:key:lightning"}}]`))
+	})
+	mux.HandleFunc("GET /api/v1/stores/store-1/invoices/invoice-1/payment-methods", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`[{"paymentMethodId": "XMR-CHAIN", "rate": "150.0", "payments": [{"id": "payment-1", "value": "0.1"}]}]`))
+	})
+	mux.HandleFunc("GET /api/v1/stores/store-1/invoices/invoice-2/payment-methods", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`[{"paymentMethodId": "BTC-LN", "rate": "50000.0", "payments": [{"id": "payment-3", "value": "0.0001"}]}]`))
+	})
+	mux.HandleFunc("POST /api/v1/stores/store-1/invoices/{id}/refund", func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&gotRefund)
+		w.Write([]byte(`{"id": "pull-payment-1", "viewLink": "https://btcpay.example.com/pull-payments/pull-payment-1"}`))
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	repo := &testRepo{}
+	b := BTCPay{
+		Store: btcpay.Store{
+			Host:       srv.URL,
+			ID:         "store-1",
+			UserAPIKey: "api-key",
+		},
+		Purchases: repo,
+	}
+
+	refund, err := b.Refund("ABC", "key", "payment-1", 0)
+	if err != nil {
+		t.Fatalf("full refund: %v", err)
+	}
+	if refund.ID != "pull-payment-1" || refund.Cents != 1500 || refund.ClaimURL == "" {
+		t.Fatalf("got %+v", refund)
+	}
+	if gotRefund.PaymentMethod != "XMR-CHAIN" || gotRefund.CustomAmount != "15.00" || gotRefund.CustomCurrency != "EUR" {
+		t.Fatalf("got refund request %+v", gotRefund)
+	}
+	if _, err := b.Refund("ABC", "key", "payment-2", 0); err == nil {
+		t.Fatalf("refunding unknown payment: got nil error")
+	}
+	repo.check(t, "refunded ABC:key BTCPay payment-1 pull-payment-1 1500")
+
+	// Lightning payments are refunded under the method name which they have been settled with
+	if _, err := b.Refund("ABC", "key", "payment-3", 0); err != nil {
+		t.Fatalf("lightning refund: %v", err)
+	}
+	if gotRefund.PaymentMethod != "BTC-LN" {
+		t.Fatalf("got refund request %+v", gotRefund)
+	}
+	repo.check(t, "refunded ABC:key BTCPayLightning payment-3 pull-payment-1 500")
+}
@@ -0,0 +1,267 @@
+package payment
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/dys2p/eco/email"
+	"github.com/dys2p/eco/ntfysh"
+)
+
+// An UnpaidPurchase is a purchase which awaits payment, like a SEPA or cash purchase.
+type UnpaidPurchase struct {
+	ID         string
+	PaymentKey string
+	Created    time.Time
+	Email      string // optional, reminders are sent by email
+	Ntfy       string // optional, reminders are published to this ntfy.sh address
+}
+
+// ReminderProgress records in an SQLite database how many reminders have been sent for each purchase, so a restart doesn't send duplicate reminders.
+type ReminderProgress struct {
+	sqldb *sql.DB
+	get   *sql.Stmt
+	set   *sql.Stmt
+	all   *sql.Stmt
+	del   *sql.Stmt
+}
+
+func OpenReminderProgress(fpath string) (*ReminderProgress, error) {
+	sqldb, err := sql.Open("sqlite3", fpath+"?_busy_timeout=10000&_journal=WAL&_sync=NORMAL&cache=shared")
+	if err != nil {
+		return nil, fmt.Errorf("opening database %s: %v", fpath, err)
+	}
+
+	if _, err := sqldb.Exec(`
+		create table if not exists payment_reminders (
+			purchase_id text    not null,
+			payment_key text    not null,
+			step        integer not null, -- number of reminders sent, plus one if the purchase has been cancelled
+			time        integer not null, -- unix timestamp
+			primary key (purchase_id, payment_key)
+		);
+	`); err != nil {
+		return nil, err
+	}
+
+	get, err := sqldb.Prepare("select step from payment_reminders where purchase_id = ? and payment_key = ?")
+	if err != nil {
+		return nil, err
+	}
+	set, err := sqldb.Prepare("insert or replace into payment_reminders (purchase_id, payment_key, step, time) values (?, ?, ?, ?)")
+	if err != nil {
+		return nil, err
+	}
+	all, err := sqldb.Prepare("select purchase_id, payment_key from payment_reminders")
+	if err != nil {
+		return nil, err
+	}
+	del, err := sqldb.Prepare("delete from payment_reminders where purchase_id = ? and payment_key = ?")
+	if err != nil {
+		return nil, err
+	}
+
+	return &ReminderProgress{
+		sqldb: sqldb,
+		get:   get,
+		set:   set,
+		all:   all,
+		del:   del,
+	}, nil
+}
+
+// Step returns the number of reminders which have been sent for the purchase, plus one if it has been cancelled.
+func (progress *ReminderProgress) Step(purchaseID, paymentKey string) (int, error) {
+	var step int
+	switch err := progress.get.QueryRow(purchaseID, paymentKey).Scan(&step); err {
+	case nil, sql.ErrNoRows:
+		return step, nil
+	default:
+		return 0, err
+	}
+}
+
+func (progress *ReminderProgress) setStep(purchaseID, paymentKey string, step int, now time.Time) error {
+	_, err := progress.set.Exec(purchaseID, paymentKey, step, now.Unix())
+	return err
+}
+
+// forget removes the progress of all purchases which are not in keep and for which done returns true.
+func (progress *ReminderProgress) forget(keep map[string]bool, done func(purchaseID, paymentKey string) bool) error {
+	rows, err := progress.all.Query()
+	if err != nil {
+		return err
+	}
+	var remove [][2]string
+	for rows.Next() {
+		var purchaseID, paymentKey string
+		if err := rows.Scan(&purchaseID, &paymentKey); err != nil {
+			rows.Close()
+			return err
+		}
+		if !keep[purchaseID+":"+paymentKey] && done(purchaseID, paymentKey) {
+			remove = append(remove, [2]string{purchaseID, paymentKey})
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for _, r := range remove {
+		if _, err := progress.del.Exec(r[0], r[1]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// A ReminderScheduler sends payment reminders for unpaid purchases and finally cancels them.
+//
+//	progress, err := payment.OpenReminderProgress("reminders.sqlite3")
+//	if err != nil {
+//		return err
+//	}
+//	scheduler := payment.ReminderScheduler{
+//		Progress:    progress,
+//		Unpaid:      db.UnpaidSEPAAndCashPurchases,
+//		Status:      db.PurchaseStatus,
+//		Reminders:   []time.Duration{7 * 24 * time.Hour, 14 * 24 * time.Hour},
+//		CancelAfter: 21 * 24 * time.Hour,
+//		Emailer:     mailer,
+//		Message:     reminderMessage,
+//		Cancel:      db.CancelPurchase,
+//	}
+//	scheduler.Run(time.Hour)
+//
+// If a purchase has missed several reminders, for example because the scheduler has not been running, only the latest one is sent.
+// Progress is recorded after a reminder has been sent, so a crash in between can cause a duplicate reminder.
+type ReminderScheduler struct {
+	Progress    *ReminderProgress
+	Unpaid      func() ([]UnpaidPurchase, error)
+	Status      func(purchaseID, paymentKey string) (Status, error) // optional, progress is removed once the purchase is missing in Unpaid and its status is final
+	Reminders   []time.Duration                                     // ages of the purchase at which reminders are sent, in ascending order
+	CancelAfter time.Duration                                       // optional, age of the purchase at which Cancel is called
+	Emailer     email.Emailer                                       // optional, required for reminders by email
+	Message     func(purchase UnpaidPurchase, reminder int) (subject, body string)
+	Cancel      func(purchase UnpaidPurchase) error // optional
+}
+
+// Run starts a goroutine which calls Check at the given interval.
+func (s ReminderScheduler) Run(interval time.Duration) {
+	go func() {
+		for ; true; time.Sleep(interval) {
+			if err := s.Check(time.Now()); err != nil {
+				log.Printf("error checking unpaid purchases: %v", err)
+			}
+		}
+	}()
+}
+
+// Check sends the reminders which are due and cancels expired purchases. It continues with the next purchase if sending a reminder fails.
+func (s ReminderScheduler) Check(now time.Time) error {
+	unpaid, err := s.Unpaid()
+	if err != nil {
+		return fmt.Errorf("getting unpaid purchases: %w", err)
+	}
+
+	var errs []error
+	keep := make(map[string]bool)
+	for _, purchase := range unpaid {
+		keep[purchase.ID+":"+purchase.PaymentKey] = true
+		if err := s.check(purchase, now); err != nil {
+			errs = append(errs, fmt.Errorf("[%s] %w", purchase.ID+":"+purchase.PaymentKey, err))
+		}
+	}
+	if err := s.Progress.forget(keep, s.done); err != nil {
+		errs = append(errs, fmt.Errorf("removing progress of paid purchases: %w", err))
+	}
+	return errors.Join(errs...)
+}
+
+// done returns true if the purchase is known to be paid or cancelled. An incomplete result of Unpaid must not reset the progress, else reminders would be sent again.
+func (s ReminderScheduler) done(purchaseID, paymentKey string) bool {
+	if s.Status == nil {
+		return false
+	}
+	status, err := s.Status(purchaseID, paymentKey)
+	if err != nil {
+		log.Printf("[%s] error getting status: %v", purchaseID+":"+paymentKey, err)
+		return false
+	}
+	return status.Final()
+}
+
+func (s ReminderScheduler) check(purchase UnpaidPurchase, now time.Time) error {
+	step, err := s.Progress.Step(purchase.ID, purchase.PaymentKey)
+	if err != nil {
+		return fmt.Errorf("getting reminder progress: %w", err)
+	}
+	if step > len(s.Reminders) {
+		return nil // cancelled
+	}
+
+	age := now.Sub(purchase.Created)
+	if s.CancelAfter > 0 && age >= s.CancelAfter {
+		if s.Cancel != nil {
+			if err := s.Cancel(purchase); err != nil {
+				return fmt.Errorf("cancelling: %w", err)
+			}
+		}
+		log.Printf("[%s] cancelled unpaid purchase", purchase.ID+":"+purchase.PaymentKey)
+		return s.Progress.setStep(purchase.ID, purchase.PaymentKey, len(s.Reminders)+1, now)
+	}
+
+	due := 0 // number of reminders which are due
+	for due < len(s.Reminders) && age >= s.Reminders[due] {
+		due++
+	}
+	if due <= step {
+		return nil
+	}
+	if err := s.send(purchase, due); err != nil {
+		return fmt.Errorf("sending reminder %d: %w", due, err)
+	}
+	log.Printf("[%s] sent payment reminder %d", purchase.ID+":"+purchase.PaymentKey, due)
+	return s.Progress.setStep(purchase.ID, purchase.PaymentKey, due, now)
+}
+
+// send sends reminder number n, starting at one, by email and ntfy. It returns an error only if every channel has failed, because the reminder would be sent again on all channels. Failures of the other channels are logged.
+func (s ReminderScheduler) send(purchase UnpaidPurchase, n int) error {
+	subject, body := s.Message(purchase, n)
+
+	var sent bool
+	var errs []error
+	if purchase.Email != "" && s.Emailer != nil {
+		if err := s.Emailer.Send(email.Email{
+			To:      purchase.Email,
+			Subject: subject,
+			Body:    []byte(body),
+		}); err != nil {
+			errs = append(errs, fmt.Errorf("email: %w", err))
+		} else {
+			sent = true
+		}
+	}
+	if strings.TrimSpace(purchase.Ntfy) != "" {
+		if err := ntfysh.Publish(purchase.Ntfy, subject, body); err != nil {
+			errs = append(errs, fmt.Errorf("ntfy: %w", err))
+		} else {
+			sent = true
+		}
+	}
+
+	if !sent && len(errs) == 0 {
+		return errors.New("no reminder channel")
+	}
+	if sent {
+		for _, err := range errs {
+			log.Printf("[%s] error sending payment reminder %d: %v", purchase.ID+":"+purchase.PaymentKey, n, err)
+		}
+		return nil
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,146 @@
+package payment
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/dys2p/eco/email"
+)
+
+type testEmailer struct {
+	err  error
+	sent []string
+}
+
+func (mailer *testEmailer) Send(em email.Email) error {
+	if mailer.err != nil {
+		return mailer.err
+	}
+	mailer.sent = append(mailer.sent, em.To+" "+em.Subject)
+	return nil
+}
+
+func TestReminderScheduler(t *testing.T) {
+	fpath := filepath.Join(t.TempDir(), "reminders.sqlite3")
+	progress, err := OpenReminderProgress(fpath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	day := 24 * time.Hour
+	created := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	unpaid := []UnpaidPurchase{
+		{ID: "ABC", PaymentKey: "k1", Created: created, Email: "abc@example.com"},
+		{ID: "DEF", PaymentKey: "k2", Created: created.Add(2 * day), Email: "def@example.com"},
+	}
+
+	mailer := &testEmailer{}
+	paid := make(map[string]bool)
+	var cancelled []string
+	scheduler := ReminderScheduler{
+		Progress: progress,
+		Unpaid:   func() ([]UnpaidPurchase, error) { return unpaid, nil },
+		Status: func(purchaseID, paymentKey string) (Status, error) {
+			if paid[purchaseID+":"+paymentKey] {
+				return StatusPaid, nil
+			}
+			return StatusPending, nil
+		},
+		Reminders:   []time.Duration{7 * day, 14 * day},
+		CancelAfter: 21 * day,
+		Emailer:     mailer,
+		Message: func(purchase UnpaidPurchase, reminder int) (string, string) {
+			return fmt.Sprintf("Reminder %d for %s", reminder, purchase.ID), ""
+		},
+		Cancel: func(purchase UnpaidPurchase) error {
+			cancelled = append(cancelled, purchase.ID)
+			return nil
+		},
+	}
+
+	check := func(now time.Time, wantSent ...string) {
+		t.Helper()
+		if err := scheduler.Check(now); err != nil {
+			t.Fatal(err)
+		}
+		if !slices.Equal(mailer.sent, wantSent) {
+			t.Fatalf("got %q, want %q", mailer.sent, wantSent)
+		}
+		mailer.sent = nil
+	}
+
+	check(created.Add(6 * day))
+	check(created.Add(7*day), "abc@example.com Reminder 1 for ABC")
+	check(created.Add(8 * day))
+
+	// restart, DEF has missed its first reminder
+	progress, err = OpenReminderProgress(fpath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	scheduler.Progress = progress
+	check(created.Add(17*day), "abc@example.com Reminder 2 for ABC", "def@example.com Reminder 2 for DEF")
+	check(created.Add(18 * day))
+
+	// failed email is retried
+	unpaid = append(unpaid, UnpaidPurchase{ID: "GHI", PaymentKey: "k3", Created: created.Add(11 * day), Email: "ghi@example.com"})
+	mailer.err = errors.New("mail server down")
+	if err := scheduler.Check(created.Add(18 * day)); err == nil {
+		t.Fatal("got no error")
+	}
+	mailer.err = nil
+	check(created.Add(19*day), "ghi@example.com Reminder 1 for GHI")
+
+	// failed ntfy after successful email is not retried, because the email would be sent again
+	unpaid = append(unpaid, UnpaidPurchase{ID: "JKL", PaymentKey: "k4", Created: created.Add(12 * day), Email: "jkl@example.com", Ntfy: "http://127.0.0.1:1/unreachable"})
+	check(created.Add(19*day), "jkl@example.com Reminder 1 for JKL")
+	check(created.Add(20 * day))
+	unpaid = unpaid[:3]
+
+	// a purchase without reminder channel is an error and is retried
+	unpaid = append(unpaid, UnpaidPurchase{ID: "MNO", PaymentKey: "k5", Created: created.Add(13 * day)})
+	if err := scheduler.Check(created.Add(20 * day)); err == nil {
+		t.Fatal("got no error")
+	}
+	if step, _ := progress.Step("MNO", "k5"); step != 0 {
+		t.Fatalf("got step %d, want 0", step)
+	}
+	unpaid = unpaid[:3]
+
+	// an empty result of Unpaid doesn't reset the progress
+	all := unpaid
+	unpaid = nil
+	check(created.Add(20 * day))
+	if step, _ := progress.Step("GHI", "k3"); step != 1 {
+		t.Fatalf("got step %d, want 1", step)
+	}
+	unpaid = all
+
+	check(created.Add(21 * day))
+	check(created.Add(23 * day))
+	if !slices.Equal(cancelled, []string{"ABC", "DEF"}) {
+		t.Fatalf("got cancelled %v", cancelled)
+	}
+	check(created.Add(24 * day))
+	if len(cancelled) != 2 {
+		t.Fatalf("got cancelled %v", cancelled)
+	}
+
+	// purchases are forgotten once their status is final
+	unpaid = unpaid[:1]
+	paid["GHI:k3"] = true
+	check(created.Add(25 * day))
+	if step, _ := progress.Step("GHI", "k3"); step != 0 {
+		t.Fatalf("got step %d, want 0", step)
+	}
+	if step, _ := progress.Step("DEF", "k2"); step != 3 {
+		t.Fatalf("got step %d, want 3", step)
+	}
+	if step, _ := progress.Step("ABC", "k1"); step != 3 {
+		t.Fatalf("got step %d, want 3", step)
+	}
+}
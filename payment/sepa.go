@@ -3,6 +3,7 @@ package payment
 import (
 	"bytes"
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"html/template"
 	"log"
@@ -10,6 +11,7 @@ import (
 	"strings"
 	"unicode"
 
+	"github.com/dys2p/eco/iban"
 	"github.com/dys2p/eco/lang"
 	qrcode "github.com/skip2/go-qrcode"
 )
@@ -18,26 +20,46 @@ var sepaTmpl = template.Must(template.ParseFS(htmlfiles, "sepa.html"))
 
 type sepaTmplData struct {
 	lang.Lang
-	Account     SEPAAccount
-	Amount      float64
-	EPCImageSrc string
-	Purpose     string
+	Account           SEPAAccount
+	Amount            float64
+	CreditorReference bool
+	EPCImageSrc       string
+	Purpose           string
 }
 
 type SEPAAccount struct {
 	Holder   string
-	IBAN     string
-	BIC      string
+	IBAN     string // whitespace is allowed
+	BIC      string // optional
 	BankName string
 }
 
+// Validate checks the account holder, the IBAN and, if given, the BIC.
+func (account SEPAAccount) Validate() error {
+	if strings.TrimSpace(account.Holder) == "" {
+		return errors.New("missing account holder")
+	}
+	if err := iban.Validate(removeWhitespaces(account.IBAN)); err != nil {
+		return fmt.Errorf("invalid IBAN %s: %w", account.IBAN, err)
+	}
+	if bic := removeWhitespaces(account.BIC); bic != "" {
+		if err := iban.ValidateBIC(bic); err != nil {
+			return fmt.Errorf("invalid BIC %s: %w", account.BIC, err)
+		}
+	}
+	return nil
+}
+
 type SEPA struct {
-	Account   SEPAAccount
-	Purchases PurchaseRepo
+	Account           SEPAAccount
+	CreditorReference bool // if true, the customer is asked to use the creditor reference of the purchase ID (see CreditorReference) as purpose
+	Purchases         PurchaseRepo
 }
 
-func (SEPA) Handler() http.Handler {
-	return http.NewServeMux()
+func (sepa SEPA) Handler() http.Handler {
+	var mux = http.NewServeMux()
+	mux.Handle("GET /payment/sepa/purchase-status", purchaseStatus(sepa.Purchases))
+	return mux
 }
 
 func (SEPA) ID() string {
@@ -48,38 +70,60 @@ func (SEPA) Name(l lang.Lang) string {
 	return l.Tr("Bank Transfer to our SEPA Account")
 }
 
+// PayHTML returns an error if the account is invalid, so customers are never shown wrong bank details.
 func (sepa SEPA) PayHTML(purchaseID, paymentKey, redirectURL string, l lang.Lang) (template.HTML, error) {
-	eurocents, err := sepa.Purchases.PurchaseSumCents(purchaseID, paymentKey)
+	if err := sepa.Account.Validate(); err != nil {
+		return template.HTML(""), fmt.Errorf("invalid SEPA account: %w", err)
+	}
+
+	eurocents, err := eurSum(sepa.Purchases, purchaseID, paymentKey)
 	if err != nil {
 		log.Printf("error getting purchase sum from database: %v", err)
 		return template.HTML("Error getting purchase information from database"), nil
 	}
 
-	epcString := `BCD
-001
-1
-SCT
-` + removeWhitespaces(sepa.Account.BIC) + `
-` + strings.TrimSpace(sepa.Account.Holder) + `
-` + removeWhitespaces(sepa.Account.IBAN) + `
-EUR` + fmt.Sprintf("%.2f", float64(eurocents)/100.0) + `
-GDSV
+	epc := EPCQR{
+		Version:     "002",
+		BIC:         removeWhitespaces(sepa.Account.BIC),
+		Name:        strings.TrimSpace(sepa.Account.Holder),
+		IBAN:        removeWhitespaces(sepa.Account.IBAN),
+		Cents:       eurocents,
+		Purpose:     "GDSV", // Purchase & Sale of Goods and Services
+		Text:        purchaseID,
+		Information: "SEPA payment for purchase",
+	}
+	purpose := purchaseID
+	if sepa.CreditorReference {
+		ref, err := CreditorReference(purchaseID)
+		if err != nil {
+			return template.HTML(""), err
+		}
+		epc.Reference = ref
+		epc.Text = ""
+		purpose = FormatCreditorReference(ref)
+	}
 
-` + purchaseID + `
-SEPA payment for purchase` // GDSV = Purchase & Sale of Goods and Services
+	epcString, err := epc.Encode()
+	if err != nil {
+		return template.HTML(""), fmt.Errorf("creating EPC QR code payload: %w", err)
+	}
 
 	epcPNG, err := qrcode.Encode(epcString, qrcode.Medium, -5)
 	if err != nil {
 		log.Printf("error creating EPC QR code: %v", err) // don't exit
 	}
 
+	account := sepa.Account
+	account.IBAN = iban.Format(account.IBAN)
+
 	buf := &bytes.Buffer{}
 	err = sepaTmpl.Execute(buf, sepaTmplData{
-		Lang:        l,
-		Account:     sepa.Account,
-		Amount:      float64(eurocents) / 100.0,
-		EPCImageSrc: base64.StdEncoding.EncodeToString(epcPNG),
-		Purpose:     purchaseID,
+		Lang:              l,
+		Account:           account,
+		Amount:            float64(eurocents) / 100.0,
+		CreditorReference: sepa.CreditorReference,
+		EPCImageSrc:       base64.StdEncoding.EncodeToString(epcPNG),
+		Purpose:           purpose,
 	})
 	return template.HTML(buf.String()), err
 }
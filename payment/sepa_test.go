@@ -0,0 +1,43 @@
+package payment
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/dys2p/eco/lang"
+	"golang.org/x/text/language"
+	"golang.org/x/text/message"
+)
+
+func TestSEPAPayHTML(t *testing.T) {
+	sepa := SEPA{
+		Account: SEPAAccount{
+			Holder: "Example",
+			IBAN:   "DE89 3704 0044 0532 0130 00",
+			BIC:    "COBADEFFXXX",
+		},
+		Purchases: &testRepo{sumCents: 1000},
+	}
+	l := lang.Lang{Printer: message.NewPrinter(language.English)}
+	html, err := sepa.PayHTML("ABCDEF", "key", "", l)
+	if err != nil {
+		t.Fatalf("rendering valid account: %v", err)
+	}
+	if !strings.Contains(string(html), "DE89 3704 0044 0532 0130 00") {
+		t.Fatalf("formatted IBAN not found in %s", html)
+	}
+
+	sepa.CreditorReference = true
+	html, err = sepa.PayHTML("ABCDEF", "key", "", l)
+	if err != nil {
+		t.Fatalf("rendering with creditor reference: %v", err)
+	}
+	if !strings.Contains(string(html), "RF02 ABCD EF") {
+		t.Fatalf("creditor reference not found in %s", html)
+	}
+
+	sepa.Account.IBAN = "DE88 3704 0044 0532 0130 00"
+	if _, err := sepa.PayHTML("ABCDEF", "key", "", l); err == nil {
+		t.Fatalf("rendering invalid account: got nil error")
+	}
+}
@@ -0,0 +1,113 @@
+package payment
+
+import (
+	"errors"
+	"fmt"
+	"log"
+)
+
+// errNotCovered is returned by splitRepo.SetPurchasePaid if the purchase is not covered yet. It makes the Ledger forget the paid event, so a later SetPurchasePaid of the same method is not dropped as a replay.
+var errNotCovered = errors.New("purchase is not covered yet")
+
+// Split lets customers pay a purchase with more than one method, for example part by voucher or cash and the rest by SEPA or BTCPay.
+//
+// Each method gets its own PurchaseRepo from Wrap. Its purchase sum is the amount which the method should collect: the purchase sum minus the payments (and refunds) of all other methods, as recorded in the Ledger.
+// SetPurchasePaid is passed to repo only if the combined payments cover the purchase sum, within the tolerances of the Reconciler.
+//
+//	split := payment.Split{
+//		Reconciler: payment.Reconciler{Ledger: ledger, Purchases: repo},
+//	}
+//	methods := []payment.Method{
+//		payment.Voucher{Store: vouchers, Purchases: split.Wrap(repo, "Voucher")},
+//		payment.SEPA{Account: account, Purchases: split.Wrap(repo, "SEPA")},
+//	}
+type Split struct {
+	Reconciler Reconciler // Reconciler.Purchases must not be wrapped
+}
+
+// Wrap returns a PurchaseRepo for the method which passes the given method name to the PurchaseRepo, like "BTCPay" or "SEPA".
+// It records events in the Ledger and reconciles the purchase like Reconciler.Wrap.
+func (s Split) Wrap(repo PurchaseRepo, methodName string) PurchaseRepo {
+	return splitPaidRepo{
+		PurchaseRepo: s.Reconciler.Wrap(splitRepo{
+			PurchaseRepo: repo,
+			split:        s,
+			methodName:   methodName,
+		}),
+	}
+}
+
+// Remaining returns the amount which the given method should collect: the purchase sum minus the settled and refunded payments of all other methods.
+// It is never negative.
+func (s Split) Remaining(purchaseID, paymentKey, methodName string) (Amount, error) {
+	sum, err := PurchaseSum(s.Reconciler.Purchases, purchaseID, paymentKey)
+	if err != nil {
+		return sum, fmt.Errorf("getting purchase sum: %w", err)
+	}
+	events, err := s.Reconciler.Ledger.Events(purchaseID)
+	if err != nil {
+		return sum, fmt.Errorf("getting ledger events: %w", err)
+	}
+	for _, event := range events {
+		if event.PaymentKey != paymentKey || event.Method == methodName {
+			continue
+		}
+		switch event.Type {
+		case EventSettled:
+			sum.Minor -= event.Cents
+		case EventRefunded:
+			sum.Minor += event.Cents
+		}
+	}
+	sum.Minor = max(0, sum.Minor)
+	return sum, nil
+}
+
+type splitRepo struct {
+	PurchaseRepo
+	split      Split
+	methodName string
+}
+
+func (sr splitRepo) PurchaseSum(purchaseID, paymentKey string) (Amount, error) {
+	return sr.split.Remaining(purchaseID, paymentKey, sr.methodName)
+}
+
+func (sr splitRepo) PurchaseSumCents(purchaseID, paymentKey string) (int, error) {
+	sum, err := sr.PurchaseSum(purchaseID, paymentKey)
+	return sum.Minor, err
+}
+
+// SetPurchasePaid returns errNotCovered if the purchase is not covered yet.
+func (sr splitRepo) SetPurchasePaid(purchaseID, paymentKey, methodName string) error {
+	rec, err := sr.split.Reconciler.Reconcile(purchaseID, paymentKey)
+	if err != nil {
+		return fmt.Errorf("reconciling payments: %w", err)
+	}
+	if rec.Balance != Paid && rec.Balance != Overpaid {
+		log.Printf("[%s] %s has collected its part, awaiting %d from other methods", purchaseID+":"+paymentKey, methodName, -rec.DiffCents)
+		return errNotCovered
+	}
+	return sr.PurchaseRepo.SetPurchasePaid(purchaseID, paymentKey, methodName)
+}
+
+// splitPaidRepo wraps the Ledger and turns errNotCovered into nil, because the method has collected its part.
+type splitPaidRepo struct {
+	PurchaseRepo
+}
+
+func (sr splitPaidRepo) PurchaseSum(purchaseID, paymentKey string) (Amount, error) {
+	return PurchaseSum(sr.PurchaseRepo, purchaseID, paymentKey)
+}
+
+func (sr splitPaidRepo) records(ledger *Ledger) bool {
+	rec, ok := sr.PurchaseRepo.(ledgerRecorder)
+	return ok && rec.records(ledger)
+}
+
+func (sr splitPaidRepo) SetPurchasePaid(purchaseID, paymentKey, methodName string) error {
+	if err := sr.PurchaseRepo.SetPurchasePaid(purchaseID, paymentKey, methodName); err != nil && err != errNotCovered {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+package payment
+
+import (
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSplit(t *testing.T) {
+	ledger, err := OpenLedger(filepath.Join(t.TempDir(), "ledger.sqlite3"))
+	if err != nil {
+		t.Fatalf("opening ledger: %v", err)
+	}
+	vouchers, err := OpenVoucherStore(filepath.Join(t.TempDir(), "vouchers.sqlite3"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, err := vouchers.Create(1000, time.Now().AddDate(1, 0, 0).Format("2006-01-02"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	repo := &testRepo{sumCents: 1500}
+	split := Split{
+		Reconciler: Reconciler{Ledger: ledger, Purchases: repo},
+	}
+	voucherRepo := split.Wrap(repo, "Voucher")
+	sepaRepo := split.Wrap(repo, "SEPA")
+
+	remaining := func(wantVoucher, wantSEPA int) {
+		t.Helper()
+		if got, err := voucherRepo.PurchaseSumCents("ABC", "key"); err != nil || got != wantVoucher {
+			t.Fatalf("voucher: got remaining %d, %v, want %d", got, err, wantVoucher)
+		}
+		if got, err := sepaRepo.PurchaseSumCents("ABC", "key"); err != nil || got != wantSEPA {
+			t.Fatalf("SEPA: got remaining %d, %v, want %d", got, err, wantSEPA)
+		}
+	}
+	remaining(1500, 1500)
+
+	// SEPA partial payment
+	if err := sepaRepo.PaymentSettled("ABC", "key", "SEPA", "tx-1", 700, false); err != nil {
+		t.Fatal(err)
+	}
+	repo.check(t, "settled ABC:key SEPA tx-1 700 false")
+	remaining(800, 1500)
+
+	// voucher pays the remaining amount only, although its balance is higher
+	w := serve(Voucher{Store: vouchers, Purchases: voucherRepo}.Handler(), http.MethodPost, "/payment/voucher/redeem", "purchase-id=ABC&payment-key=key&code="+code)
+	if w.Code != http.StatusSeeOther {
+		t.Fatalf("got %d %s", w.Code, w.Body)
+	}
+	repo.check(t, "settled ABC:key Voucher 2 800 false", "paid ABC:key Voucher")
+	remaining(800, 700)
+
+	if voucher, err := vouchers.Get(code); err != nil || voucher.BalanceCents != 200 {
+		t.Fatalf("got voucher %+v, %v", voucher, err)
+	}
+}
+
+func TestSplitUnderpaid(t *testing.T) {
+	ledger, err := OpenLedger(filepath.Join(t.TempDir(), "ledger.sqlite3"))
+	if err != nil {
+		t.Fatalf("opening ledger: %v", err)
+	}
+	repo := &testRepo{sumCents: 1500}
+	split := Split{
+		Reconciler: Reconciler{Ledger: ledger, Purchases: repo},
+	}
+	cashRepo := split.Wrap(repo, "Cash")
+	stripeRepo := split.Wrap(repo, "Stripe")
+
+	if err := cashRepo.PaymentSettled("ABC", "key", "Cash", "cash-1", 1500, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := cashRepo.PaymentRefunded("ABC", "key", "Cash", "cash-1", "refund-1", 500); err != nil {
+		t.Fatal(err)
+	}
+	repo.check(t, "settled ABC:key Cash cash-1 1500 false", "refunded ABC:key Cash cash-1 refund-1 500")
+
+	// Stripe pays less than its part of 500, so the purchase is not paid yet
+	if err := stripeRepo.PaymentSettled("ABC", "key", "Stripe", "pi-1", 400, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := stripeRepo.SetPurchasePaid("ABC", "key", "Stripe"); err != nil {
+		t.Fatal(err)
+	}
+	repo.check(t, "settled ABC:key Stripe pi-1 400 false")
+
+	// a later SetPurchasePaid of the same method is not dropped as a replay
+	if err := stripeRepo.PaymentSettled("ABC", "key", "Stripe", "pi-2", 100, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := stripeRepo.SetPurchasePaid("ABC", "key", "Stripe"); err != nil {
+		t.Fatal(err)
+	}
+	repo.check(t, "settled ABC:key Stripe pi-2 100 false", "paid ABC:key Stripe")
+}
@@ -0,0 +1,183 @@
+package statement
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// XML elements are matched by their local names, so all camt.053 and camt.054 versions are supported.
+type camtDocument struct {
+	Statements    []camtAccount `xml:"BkToCstmrStmt>Stmt"`
+	Notifications []camtAccount `xml:"BkToCstmrDbtCdtNtfctn>Ntfctn"`
+}
+
+type camtAccount struct {
+	Entries []camtEntry `xml:"Ntry"`
+}
+
+type camtAmount struct {
+	Currency string `xml:"Ccy,attr"`
+	Value    string `xml:",chardata"`
+}
+
+type camtDate struct {
+	Date     string `xml:"Dt"`
+	DateTime string `xml:"DtTm"`
+}
+
+func (d camtDate) String() string {
+	if d.Date != "" {
+		return d.Date
+	}
+	if len(d.DateTime) >= 10 {
+		return d.DateTime[:10]
+	}
+	return ""
+}
+
+type camtParty struct {
+	Name    string `xml:"Nm"`
+	PtyName string `xml:"Pty>Nm"` // camt.053.001.08 and later
+}
+
+func (p camtParty) String() string {
+	if p.Name != "" {
+		return p.Name
+	}
+	return p.PtyName
+}
+
+type camtStatus struct {
+	Value string `xml:",chardata"`
+	Code  string `xml:"Cd"` // camt.053.001.08 and later
+}
+
+func (s camtStatus) String() string {
+	return strings.TrimSpace(s.Value + s.Code)
+}
+
+type camtEntry struct {
+	Reference   string     `xml:"NtryRef"`
+	Amount      camtAmount `xml:"Amt"`
+	CreditDebit string     `xml:"CdtDbtInd"`
+	Reversal    bool       `xml:"RvslInd"`
+	Status      camtStatus `xml:"Sts"`
+	BookingDate camtDate   `xml:"BookgDt"`
+	ValueDate   camtDate   `xml:"ValDt"`
+	ServicerRef string     `xml:"AcctSvcrRef"`
+	Details     []camtTx   `xml:"NtryDtls>TxDtls"`
+}
+
+type camtTx struct {
+	ServicerRef   string     `xml:"Refs>AcctSvcrRef"`
+	EndToEndID    string     `xml:"Refs>EndToEndId"`
+	Amount        camtAmount `xml:"Amt"`
+	TxAmount      camtAmount `xml:"AmtDtls>TxAmt>Amt"`
+	Debtor        camtParty  `xml:"RltdPties>Dbtr"`
+	Creditor      camtParty  `xml:"RltdPties>Cdtr"`
+	Unstructured  []string   `xml:"RmtInf>Ustrd"`
+	CreditorRefs  []string   `xml:"RmtInf>Strd>CdtrRefInf>Ref"`
+	AddtlRmtInfos []string   `xml:"RmtInf>Strd>AddtlRmtInf"`
+}
+
+func (tx camtTx) remittance() string {
+	var parts []string
+	parts = append(parts, tx.CreditorRefs...)
+	parts = append(parts, tx.Unstructured...)
+	parts = append(parts, tx.AddtlRmtInfos...)
+	return strings.Join(parts, "\n")
+}
+
+// ParseCAMT parses ISO 20022 camt.053 (statement) and camt.054 (notification) documents.
+// Entries which are not booked (e. g. pending) are skipped.
+// Batch entries with several transaction details are split into one Transaction per detail.
+func ParseCAMT(r io.Reader) ([]Transaction, error) {
+	var doc camtDocument
+	if err := xml.NewDecoder(r).Decode(&doc); err != nil {
+		return nil, fmt.Errorf("decoding camt document: %w", err)
+	}
+
+	var transactions []Transaction
+	for _, account := range append(doc.Statements, doc.Notifications...) {
+		for _, entry := range account.Entries {
+			if status := entry.Status.String(); status != "" && status != "BOOK" {
+				continue
+			}
+			date := entry.BookingDate.String()
+			if date == "" {
+				date = entry.ValueDate.String()
+			}
+			credit := entry.CreditDebit == "CRDT"
+
+			if len(entry.Details) <= 1 {
+				tx := Transaction{
+					BookingDate: date,
+					Currency:    entry.Amount.Currency,
+					Credit:      credit,
+					Reversal:    entry.Reversal,
+					Reference:   firstNonEmpty(entry.ServicerRef, entry.Reference),
+				}
+				var err error
+				if tx.Cents, err = parseAmount(entry.Amount.Value); err != nil {
+					return nil, err
+				}
+				if len(entry.Details) == 1 {
+					detail := entry.Details[0]
+					tx.Name = detail.party(credit)
+					tx.Remittance = detail.remittance()
+					tx.Reference = firstNonEmpty(tx.Reference, detail.ServicerRef, detail.EndToEndID)
+				}
+				transactions = append(transactions, withReference(tx))
+				continue
+			}
+
+			for _, detail := range entry.Details {
+				amount := detail.Amount
+				if amount.Value == "" {
+					amount = detail.TxAmount
+				}
+				tx := Transaction{
+					BookingDate: date,
+					Currency:    firstNonEmpty(amount.Currency, entry.Amount.Currency),
+					Credit:      credit,
+					Reversal:    entry.Reversal,
+					Reference:   detail.ServicerRef, // entry reference is not unique
+					Name:        detail.party(credit),
+					Remittance:  detail.remittance(),
+				}
+				var err error
+				if tx.Cents, err = parseAmount(amount.Value); err != nil {
+					return nil, err
+				}
+				transactions = append(transactions, withReference(tx))
+			}
+		}
+	}
+	return transactions, nil
+}
+
+// party returns the name of the other party.
+func (tx camtTx) party(credit bool) string {
+	if credit {
+		return tx.Debtor.String()
+	}
+	return tx.Creditor.String()
+}
+
+func firstNonEmpty(ss ...string) string {
+	for _, s := range ss {
+		if s = strings.TrimSpace(s); s != "" && s != "NONREF" && s != "NOTPROVIDED" {
+			return s
+		}
+	}
+	return ""
+}
+
+func withReference(tx Transaction) Transaction {
+	if tx.Reference == "" {
+		tx.Reference = hashReference(tx)
+	}
+	return tx
+}
@@ -0,0 +1,246 @@
+package statement
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"unicode"
+
+	"github.com/dys2p/eco/payment"
+)
+
+// A Purchase awaits a bank transfer.
+type Purchase struct {
+	ID         string
+	PaymentKey string
+}
+
+type Status string
+
+const (
+	Matched        Status = "matched"         // PaymentSettled and SetPurchasePaid have been called
+	AmountMismatch Status = "amount-mismatch" // purchase found, but amount or currency differ, manual review required
+	Fuzzy          Status = "fuzzy"           // purchase found with typos only, amount and currency match, manual review required
+	Ambiguous      Status = "ambiguous"       // more than one purchase found, manual review required
+	NoMatch        Status = "no-match"        // no purchase found, manual review required
+	Ignored        Status = "ignored"         // outgoing payment or reversal
+)
+
+type Result struct {
+	Transaction
+	Status     Status
+	PurchaseID string // Matched, AmountMismatch and Fuzzy only
+	PaymentKey string // Matched, AmountMismatch and Fuzzy only
+	DueCents   int    // Matched, AmountMismatch and Fuzzy only
+	Err        error  // error from the PurchaseRepo
+}
+
+// NeedsReview returns true if the result should be checked by a human.
+func (result Result) NeedsReview() bool {
+	return result.Err != nil || result.Status == AmountMismatch || result.Status == Fuzzy || result.Status == Ambiguous || result.Status == NoMatch
+}
+
+// A Matcher finds purchase IDs in the remittance information of incoming transfers.
+//
+// Purchase IDs are expected to consist of id.AlphanumCaseInsensitiveDigits, so matching is case-insensitive and the letter I is read as the digit 1.
+// Creditor references (see payment.CreditorReference) are verified by their check digits and take precedence.
+// Otherwise the remittance information is split into words. A purchase ID matches a word or, in case the customer or the bank inserted spaces, consecutive words. It never matches a part of a word.
+// If no purchase ID is found exactly, the Matcher looks for purchase IDs with up to MaxTypos edits (insertion, deletion, substitution or transposition of adjacent characters).
+// Such fuzzy matches are never settled automatically, but reported with the status Fuzzy.
+type Matcher struct {
+	MaxTypos  int // recommended: 1 for six-digit purchase IDs
+	Purchases payment.PurchaseRepo
+	Unpaid    func() ([]Purchase, error) // returns the purchases which await a bank transfer
+}
+
+// Import matches the transactions against the unpaid purchases. If the purchase is found and the amount matches, the payment is reported to Purchases.
+//
+// Import can be called with overlapping statements if Purchases is wrapped with payment.Ledger.Wrap, because the bank reference is used as payment ID.
+func (m Matcher) Import(transactions []Transaction) ([]Result, error) {
+	unpaid, err := m.Unpaid()
+	if err != nil {
+		return nil, fmt.Errorf("getting unpaid purchases: %w", err)
+	}
+
+	var results []Result
+	for _, tx := range transactions {
+		result := Result{Transaction: tx}
+		if !tx.Credit || tx.Reversal {
+			result.Status = Ignored
+			results = append(results, result)
+			continue
+		}
+
+		candidates, fuzzy := m.find(tx.Remittance, unpaid)
+		switch len(candidates) {
+		case 0:
+			result.Status = NoMatch
+		case 1:
+			result.PurchaseID = candidates[0].ID
+			result.PaymentKey = candidates[0].PaymentKey
+			result.Status, result.DueCents, result.Err = m.settle(tx, candidates[0], fuzzy)
+		default:
+			result.Status = Ambiguous
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// settle reports the payment if the amount matches. Fuzzy matches are not reported.
+func (m Matcher) settle(tx Transaction, purchase Purchase, fuzzy bool) (Status, int, error) {
+	due, err := payment.PurchaseSum(m.Purchases, purchase.ID, purchase.PaymentKey)
+	if err != nil {
+		return AmountMismatch, 0, fmt.Errorf("getting sum of purchase %s: %w", purchase.ID, err)
+	}
+	dueCents := due.Minor
+	if tx.Currency != due.Currency || tx.Cents != dueCents {
+		return AmountMismatch, dueCents, nil
+	}
+	if fuzzy {
+		return Fuzzy, dueCents, nil
+	}
+	if err := m.Purchases.PaymentSettled(purchase.ID, purchase.PaymentKey, "SEPA", tx.Reference, tx.Cents, false); err != nil {
+		return Matched, dueCents, err
+	}
+	if err := m.Purchases.SetPurchasePaid(purchase.ID, purchase.PaymentKey, "SEPA"); err != nil {
+		return Matched, dueCents, err
+	}
+	return Matched, dueCents, nil
+}
+
+// find returns the unpaid purchases whose IDs are found in the remittance information, and whether they have been found by fuzzy matching.
+// Creditor references take precedence over exact matches, which take precedence over fuzzy matches.
+func (m Matcher) find(remittance string, unpaid []Purchase) ([]Purchase, bool) {
+	var referenced []Purchase
+	for _, ref := range creditorReferences(remittance) {
+		for _, purchase := range unpaid {
+			if normalize(purchase.ID) == normalize(ref) {
+				referenced = append(referenced, purchase)
+			}
+		}
+	}
+	if len(referenced) > 0 {
+		return referenced, false
+	}
+
+	words := strings.FieldsFunc(remittance, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for i := range words {
+		words[i] = normalize(words[i])
+	}
+
+	var exact []Purchase
+	for _, purchase := range unpaid {
+		if id := normalize(purchase.ID); id != "" && containsWords(words, id) {
+			exact = append(exact, purchase)
+		}
+	}
+	if len(exact) > 0 || m.MaxTypos <= 0 {
+		return exact, false
+	}
+
+	// fuzzy matching of words, and of pairs of adjacent words in case the customer inserted a space
+	var best []Purchase
+	var bestDistance = m.MaxTypos + 1
+	for _, purchase := range unpaid {
+		id := normalize(purchase.ID)
+		if len(id) <= m.MaxTypos {
+			continue // would match anything
+		}
+		distance := m.MaxTypos + 1
+		for i := range words {
+			distance = min(distance, osaDistance(id, words[i]))
+			if i+1 < len(words) {
+				distance = min(distance, osaDistance(id, words[i]+words[i+1]))
+			}
+		}
+		switch {
+		case distance < bestDistance:
+			best = []Purchase{purchase}
+			bestDistance = distance
+		case distance == bestDistance && distance <= m.MaxTypos:
+			best = append(best, purchase)
+		}
+	}
+	return best, true
+}
+
+// creditorReferences returns the purchase IDs of all valid creditor references in the remittance information.
+// As banks and customers may insert spaces, each occurrence of "RF" is tried with all possible lengths, ignoring whitespace.
+func creditorReferences(remittance string) []string {
+	var ids []string
+	parts := strings.FieldsFunc(strings.ToUpper(remittance), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && !unicode.IsSpace(r)
+	})
+	for _, part := range parts {
+		part = strings.Join(strings.Fields(part), "")
+		for start := 0; start < len(part); start++ {
+			if !strings.HasPrefix(part[start:], "RF") {
+				continue
+			}
+			for end := start + 5; end <= min(start+25, len(part)); end++ {
+				if id, err := payment.ParseCreditorReference(part[start:end]); err == nil && !slices.Contains(ids, id) {
+					ids = append(ids, id)
+				}
+			}
+		}
+	}
+	return ids
+}
+
+// containsWords returns true if id equals one word or the concatenation of consecutive words.
+func containsWords(words []string, id string) bool {
+	for i := range words {
+		concat := ""
+		for j := i; j < len(words) && len(concat) < len(id); j++ {
+			concat += words[j]
+			if concat == id {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// normalize converts s to upper case, replaces I by 1 because id.AlphanumCaseInsensitiveDigits contains 1 but not I, and removes all characters which are not letters or digits.
+func normalize(s string) string {
+	var result strings.Builder
+	for _, r := range strings.ToUpper(s) {
+		if r == 'I' {
+			r = '1'
+		}
+		if 'A' <= r && r <= 'Z' || '0' <= r && r <= '9' {
+			result.WriteRune(r)
+		}
+	}
+	return result.String()
+}
+
+// osaDistance returns the optimal string alignment distance between a and b.
+func osaDistance(a, b string) int {
+	p, t := []rune(a), []rune(b)
+	// d[i][j] is the distance between p[:i] and t[:j]
+	d := make([][]int, len(p)+1)
+	for i := range d {
+		d[i] = make([]int, len(t)+1)
+		d[i][0] = i
+	}
+	for j := range d[0] {
+		d[0][j] = j
+	}
+	for i := 1; i <= len(p); i++ {
+		for j := 1; j <= len(t); j++ {
+			cost := 1
+			if p[i-1] == t[j-1] {
+				cost = 0
+			}
+			d[i][j] = min(d[i-1][j]+1, d[i][j-1]+1, d[i-1][j-1]+cost)
+			if i > 1 && j > 1 && p[i-1] == t[j-2] && p[i-2] == t[j-1] {
+				d[i][j] = min(d[i][j], d[i-2][j-2]+1)
+			}
+		}
+	}
+	return d[len(p)][len(t)]
+}
@@ -0,0 +1,175 @@
+package statement
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"time"
+)
+
+var mt940Tag = regexp.MustCompile(`^:([0-9]{2}[A-Z]?):`)
+
+type mt940Field struct {
+	Tag   string
+	Lines []string
+}
+
+// ParseMT940 parses SWIFT MT940 statements. It understands the structured :86: format of German banks (subfields like "?20").
+func ParseMT940(r io.Reader) ([]Transaction, error) {
+	var fields []mt940Field
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if match := mt940Tag.FindStringSubmatch(line); match != nil {
+			fields = append(fields, mt940Field{
+				Tag:   match[1],
+				Lines: []string{line[len(match[0]):]},
+			})
+			continue
+		}
+		if line == "-" || line == "" || strings.HasPrefix(line, "{") {
+			continue // end of statement or SWIFT header
+		}
+		if len(fields) > 0 {
+			last := &fields[len(fields)-1]
+			last.Lines = append(last.Lines, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	var transactions []Transaction
+	var currency string
+	var pending *Transaction
+	flush := func() {
+		if pending != nil {
+			transactions = append(transactions, withReference(*pending))
+			pending = nil
+		}
+	}
+	for _, field := range fields {
+		switch field.Tag {
+		case "60F", "60M":
+			// opening balance, like "C240102EUR1234,56"
+			if len(field.Lines[0]) >= 10 {
+				currency = field.Lines[0][7:10]
+			}
+		case "61":
+			flush()
+			tx, err := parseMT940Line61(field.Lines[0])
+			if err != nil {
+				return nil, err
+			}
+			tx.Currency = currency
+			pending = &tx
+		case "86":
+			if pending != nil {
+				pending.Name, pending.Remittance = parseMT940Line86(field.Lines)
+				flush()
+			}
+		}
+	}
+	flush()
+	return transactions, nil
+}
+
+// parseMT940Line61 parses a statement line like "2401020102CR50,00NTRFNONREF//9876543210".
+func parseMT940Line61(s string) (Transaction, error) {
+	var tx Transaction
+	if len(s) < 6 {
+		return tx, fmt.Errorf("invalid statement line: %s", s)
+	}
+	valueDate, err := time.Parse("060102", s[:6])
+	if err != nil {
+		return tx, fmt.Errorf("invalid value date in statement line: %s", s)
+	}
+	tx.BookingDate = valueDate.Format(time.DateOnly)
+	s = s[6:]
+
+	// optional entry date MMDD, the year is taken from the value date
+	if len(s) >= 4 && digitsOnly(s[:4]) {
+		entryDate, err := time.Parse("20060102", valueDate.Format("2006")+s[:4])
+		if err != nil {
+			return tx, fmt.Errorf("invalid entry date in statement line: %s", s)
+		}
+		switch diff := entryDate.Sub(valueDate); {
+		case diff > 180*24*time.Hour: // e. g. entry date 12-31, value date 01-02
+			entryDate = entryDate.AddDate(-1, 0, 0)
+		case diff < -180*24*time.Hour:
+			entryDate = entryDate.AddDate(1, 0, 0)
+		}
+		tx.BookingDate = entryDate.Format(time.DateOnly)
+		s = s[4:]
+	}
+
+	// debit/credit mark, reversals are treated as the opposite
+	switch {
+	case strings.HasPrefix(s, "RC"):
+		tx.Reversal = true
+		s = s[2:]
+	case strings.HasPrefix(s, "RD"):
+		tx.Credit = true
+		tx.Reversal = true
+		s = s[2:]
+	case strings.HasPrefix(s, "C"):
+		tx.Credit = true
+		s = s[1:]
+	case strings.HasPrefix(s, "D"):
+		s = s[1:]
+	default:
+		return tx, fmt.Errorf("invalid debit/credit mark in statement line: %s", s)
+	}
+
+	// optional funds code (third character of the currency code)
+	if len(s) > 0 && (s[0] < '0' || s[0] > '9') {
+		s = s[1:]
+	}
+
+	amountEnd := strings.IndexFunc(s, func(r rune) bool {
+		return (r < '0' || r > '9') && r != ','
+	})
+	if amountEnd < 0 {
+		amountEnd = len(s)
+	}
+	if tx.Cents, err = parseAmount(s[:amountEnd]); err != nil {
+		return tx, err
+	}
+	s = s[amountEnd:]
+
+	// transaction type, like "NTRF"
+	if len(s) >= 4 {
+		s = s[4:]
+	}
+	customerRef, bankRef, _ := strings.Cut(s, "//")
+	tx.Reference = firstNonEmpty(bankRef, customerRef)
+	return tx, nil
+}
+
+// parseMT940Line86 returns the name of the other party and the remittance information.
+func parseMT940Line86(lines []string) (name, remittance string) {
+	info := strings.Join(lines, "")
+	if len(info) < 4 || !digitsOnly(info[:3]) || info[3] != '?' {
+		return "", strings.Join(lines, " ") // unstructured
+	}
+
+	var purpose strings.Builder
+	for _, subfield := range strings.Split(info[4:], "?") {
+		if len(subfield) < 2 {
+			continue
+		}
+		switch code, value := subfield[:2], subfield[2:]; {
+		case code >= "20" && code <= "29", code >= "60" && code <= "63":
+			purpose.WriteString(value)
+		case code == "32", code == "33":
+			name += value
+		}
+	}
+	remittance = purpose.String()
+	if _, after, ok := strings.Cut(remittance, "SVWZ+"); ok {
+		remittance = after // SEPA remittance information
+	}
+	return strings.TrimSpace(name), strings.TrimSpace(remittance)
+}
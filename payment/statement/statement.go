@@ -0,0 +1,66 @@
+// Package statement parses bank statements and matches incoming SEPA transfers to purchases.
+//
+// Supported formats are ISO 20022 camt.053 and camt.054 (XML) and SWIFT MT940.
+package statement
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// A Transaction is a single booking on a bank statement.
+type Transaction struct {
+	BookingDate string // yyyy-mm-dd
+	Cents       int    // always positive, see Credit
+	Currency    string // ISO 4217
+	Credit      bool   // true for incoming payments
+	Reversal    bool   // true if the booking reverses an earlier one, e. g. a returned transfer
+	Reference   string // unique reference assigned by the bank, or a hash of the transaction if the bank does not assign one
+	Name        string // name of the other party
+	Remittance  string // remittance information, including structured creditor references
+}
+
+// Parse detects the format of a bank statement and parses it.
+func Parse(data []byte) ([]Transaction, error) {
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf")) // UTF-8 BOM
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("<")) {
+		return ParseCAMT(bytes.NewReader(data))
+	}
+	return ParseMT940(bytes.NewReader(data))
+}
+
+// parseAmount parses a non-negative decimal amount with a dot or comma separator, like "12.34" or "12,3", into cents.
+func parseAmount(s string) (int, error) {
+	s = strings.TrimSpace(s)
+	units, fraction, _ := strings.Cut(strings.Replace(s, ",", ".", 1), ".")
+	fraction = strings.TrimRight(fraction, "0")
+	if units == "" || !digitsOnly(units) || !digitsOnly(fraction) || len(fraction) > 2 {
+		return 0, fmt.Errorf("invalid amount: %s", s)
+	}
+	fraction = fraction + strings.Repeat("0", 2-len(fraction))
+	u, err := strconv.Atoi(units)
+	if err != nil {
+		return 0, fmt.Errorf("parsing amount %s: %w", s, err)
+	}
+	f, _ := strconv.Atoi(fraction)
+	return u*100 + f, nil
+}
+
+func digitsOnly(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// hashReference returns a reference for transactions without a bank reference, so the same transaction yields the same payment ID on each import.
+func hashReference(tx Transaction) string {
+	sum := sha256.Sum256(fmt.Appendf(nil, "%s|%d|%s|%t|%t|%s|%s", tx.BookingDate, tx.Cents, tx.Currency, tx.Credit, tx.Reversal, tx.Name, tx.Remittance))
+	return "sha256:" + hex.EncodeToString(sum[:12])
+}
@@ -0,0 +1,235 @@
+package statement
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/dys2p/eco/payment"
+)
+
+const camt053 = `<?xml version="1.0" encoding="UTF-8"?>
+<Document xmlns="urn:iso:std:iso:20022:tech:xsd:camt.053.001.02">
+	<BkToCstmrStmt>
+		<Stmt>
+			<Ntry>
+				<Amt Ccy="EUR">42.50</Amt>
+				<CdtDbtInd>CRDT</CdtDbtInd>
+				<Sts>BOOK</Sts>
+				<BookgDt><Dt>2024-01-02</Dt></BookgDt>
+				<AcctSvcrRef>REF-1</AcctSvcrRef>
+				<NtryDtls>
+					<TxDtls>
+						<RltdPties><Dbtr><Nm>Erika Mustermann</Nm></Dbtr></RltdPties>
+						<RmtInf><Ustrd>Bestellung abc def</Ustrd></RmtInf>
+					</TxDtls>
+				</NtryDtls>
+			</Ntry>
+			<Ntry>
+				<Amt Ccy="EUR">10</Amt>
+				<CdtDbtInd>CRDT</CdtDbtInd>
+				<Sts>PDNG</Sts>
+				<BookgDt><Dt>2024-01-02</Dt></BookgDt>
+			</Ntry>
+			<Ntry>
+				<Amt Ccy="EUR">30.00</Amt>
+				<CdtDbtInd>CRDT</CdtDbtInd>
+				<Sts>BOOK</Sts>
+				<BookgDt><Dt>2024-01-03</Dt></BookgDt>
+				<AcctSvcrRef>BATCH-1</AcctSvcrRef>
+				<NtryDtls>
+					<TxDtls>
+						<Refs><AcctSvcrRef>REF-2</AcctSvcrRef></Refs>
+						<AmtDtls><TxAmt><Amt Ccy="EUR">20.00</Amt></TxAmt></AmtDtls>
+						<RmtInf><Ustrd>GHJKMN</Ustrd></RmtInf>
+					</TxDtls>
+					<TxDtls>
+						<Refs><AcctSvcrRef>REF-3</AcctSvcrRef></Refs>
+						<AmtDtls><TxAmt><Amt Ccy="EUR">10.00</Amt></TxAmt></AmtDtls>
+						<RmtInf><Ustrd>Order PQRTSU</Ustrd></RmtInf>
+					</TxDtls>
+				</NtryDtls>
+			</Ntry>
+			<Ntry>
+				<Amt Ccy="EUR">99.00</Amt>
+				<CdtDbtInd>DBIT</CdtDbtInd>
+				<Sts>BOOK</Sts>
+				<BookgDt><Dt>2024-01-03</Dt></BookgDt>
+				<AcctSvcrRef>REF-4</AcctSvcrRef>
+			</Ntry>
+			<Ntry>
+				<Amt Ccy="EUR">25.00</Amt>
+				<CdtDbtInd>CRDT</CdtDbtInd>
+				<RvslInd>true</RvslInd>
+				<Sts>BOOK</Sts>
+				<BookgDt><Dt>2024-01-04</Dt></BookgDt>
+				<AcctSvcrRef>REF-5</AcctSvcrRef>
+				<NtryDtls>
+					<TxDtls>
+						<RmtInf><Ustrd>Rueckbuchung GHJKMN</Ustrd></RmtInf>
+					</TxDtls>
+				</NtryDtls>
+			</Ntry>
+		</Stmt>
+	</BkToCstmrStmt>
+</Document>`
+
+const mt940 = `:20:STARTUMSE
+:25:10020030/1234567
+:28C:00001/001
+:60F:C231229EUR1234,56
+:61:2401021229CR12,00NTRFNONREF//BANKREF-1
+:86:166?00GUTSCHRIFT?109310?20EREF+NOTPROVIDED?21SVWZ+Bestellung VWX?22YZ2?32Max Mustermann
+:61:240103D5,NMSCNONREF
+:86:Kontofuehrung
+:62F:C240103EUR1241,56
+-`
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		input string
+		want  []Transaction
+	}{
+		{
+			camt053,
+			[]Transaction{
+				{"2024-01-02", 4250, "EUR", true, false, "REF-1", "Erika Mustermann", "Bestellung abc def"},
+				{"2024-01-03", 2000, "EUR", true, false, "REF-2", "", "GHJKMN"},
+				{"2024-01-03", 1000, "EUR", true, false, "REF-3", "", "Order PQRTSU"},
+				{"2024-01-03", 9900, "EUR", false, false, "REF-4", "", ""},
+				{"2024-01-04", 2500, "EUR", true, true, "REF-5", "", "Rueckbuchung GHJKMN"},
+			},
+		},
+		{
+			mt940,
+			[]Transaction{
+				{"2023-12-29", 1200, "EUR", true, false, "BANKREF-1", "Max Mustermann", "Bestellung VWXYZ2"},
+				{"2024-01-03", 500, "EUR", false, false, "", "", "Kontofuehrung"},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		got, err := Parse([]byte(test.input))
+		if err != nil {
+			t.Fatalf("parsing: %v", err)
+		}
+		if len(got) != len(test.want) {
+			t.Fatalf("got %d transactions, want %d", len(got), len(test.want))
+		}
+		for i := range got {
+			if test.want[i].Reference == "" {
+				if !strings.HasPrefix(got[i].Reference, "sha256:") {
+					t.Fatalf("got reference %s, want hash", got[i].Reference)
+				}
+				test.want[i].Reference = got[i].Reference
+			}
+			if got[i] != test.want[i] {
+				t.Fatalf("got %+v, want %+v", got[i], test.want[i])
+			}
+		}
+	}
+}
+
+type testRepo struct {
+	calls []string
+	sums  map[string]int
+}
+
+func (repo *testRepo) PaymentRefunded(purchaseID, paymentKey, methodName, paymentID, refundID string, refundCents int) error {
+	return nil
+}
+
+func (repo *testRepo) PaymentSettled(purchaseID, paymentKey, methodName, paymentID string, paymentCents int, paidLate bool) error {
+	repo.calls = append(repo.calls, fmt.Sprintf("settled %s:%s %s %s %d", purchaseID, paymentKey, methodName, paymentID, paymentCents))
+	return nil
+}
+
+func (repo *testRepo) PurchaseCreationDate(purchaseID, paymentKey string) (string, error) {
+	return "", nil
+}
+
+func (repo *testRepo) PurchaseStatus(purchaseID, paymentKey string) (payment.Status, error) {
+	return payment.StatusPending, nil
+}
+
+func (repo *testRepo) PurchaseSumCents(purchaseID, paymentKey string) (int, error) {
+	return repo.sums[purchaseID], nil
+}
+
+func (repo *testRepo) SetPurchasePaid(purchaseID, paymentKey, methodName string) error {
+	repo.calls = append(repo.calls, fmt.Sprintf("paid %s:%s %s", purchaseID, paymentKey, methodName))
+	return nil
+}
+
+func (repo *testRepo) SetPurchaseProcessing(purchaseID, paymentKey string) error {
+	return nil
+}
+
+func TestImport(t *testing.T) {
+	repo := &testRepo{
+		sums: map[string]int{"ABCDEF": 4250, "GHJKMN": 2500, "PQRSTU": 1000, "VWXYZ2": 1200, "VWXYZ3": 1200},
+	}
+	matcher := Matcher{
+		MaxTypos:  1,
+		Purchases: repo,
+		Unpaid: func() ([]Purchase, error) {
+			return []Purchase{{"ABCDEF", "k1"}, {"GHJKMN", "k2"}, {"PQRSTU", "k3"}, {"VWXYZ2", "k4"}, {"VWXYZ3", "k5"}}, nil
+		},
+	}
+
+	camtTransactions, _ := Parse([]byte(camt053))
+	mt940Transactions, _ := Parse([]byte(mt940))
+	results, err := matcher.Import(append(camtTransactions, mt940Transactions...))
+	if err != nil {
+		t.Fatalf("importing: %v", err)
+	}
+
+	var got []string
+	for _, result := range results {
+		got = append(got, fmt.Sprintf("%s %s %t", result.Status, result.PurchaseID, result.NeedsReview()))
+	}
+	want := []string{
+		"matched ABCDEF false",        // exact match with spaces and lower case
+		"amount-mismatch GHJKMN true", // 20.00 of 25.00
+		"fuzzy PQRSTU true",           // transposition
+		"ignored  false",              // debit
+		"ignored  false",              // reversal
+		"matched VWXYZ2 false",        // exact match spanning two subfields
+		"ignored  false",              // debit
+	}
+	if !slices.Equal(got, want) {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+
+	wantCalls := []string{
+		"settled ABCDEF:k1 SEPA REF-1 4250",
+		"paid ABCDEF:k1 SEPA",
+		"settled VWXYZ2:k4 SEPA BANKREF-1 1200",
+		"paid VWXYZ2:k4 SEPA",
+	}
+	if !slices.Equal(repo.calls, wantCalls) {
+		t.Fatalf("got calls %q, want %q", repo.calls, wantCalls)
+	}
+
+	// creditor reference, split by the bank, takes precedence over the plain purchase ID
+	if got, fuzzy := matcher.find("RF83 GHJK MN thanks for ABCDEF", []Purchase{{"ABCDEF", ""}, {"GHJKMN", ""}}); len(got) != 1 || got[0].ID != "GHJKMN" || fuzzy {
+		t.Fatalf("got %v, want GHJKMN", got)
+	}
+
+	// purchase ID must not match across word boundaries
+	if got, _ := matcher.find("XABC DEFX", []Purchase{{"ABCDEF", ""}}); len(got) != 0 {
+		t.Fatalf("got %v, want no match", got)
+	}
+
+	// ambiguous fuzzy match
+	if got, fuzzy := matcher.find("VWXYZ4", []Purchase{{"VWXYZ2", ""}, {"VWXYZ3", ""}}); len(got) != 2 || !fuzzy {
+		t.Fatalf("got %v, want two fuzzy candidates", got)
+	}
+
+	// the letter I is read as the digit 1, which is not a typo
+	if got, fuzzy := matcher.find("purchase ghjkmi", []Purchase{{"GHJKM1", ""}}); len(got) != 1 || fuzzy {
+		t.Fatalf("got %v, want exact match", got)
+	}
+}
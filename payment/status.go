@@ -0,0 +1,136 @@
+package payment
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/dys2p/eco/lang"
+)
+
+var statusTmpl = template.Must(template.ParseFS(htmlfiles, "status.html"))
+
+// Status is the state of a purchase, as seen by the customer.
+type Status string
+
+const (
+	StatusPending    Status = "pending"    // awaiting payment
+	StatusProcessing Status = "processing" // payment received, but not settled yet
+	StatusPaid       Status = "paid"
+	StatusExpired    Status = "expired" // the purchase or invoice has expired before it was paid
+	StatusFailed     Status = "failed"  // the payment has failed or has been cancelled
+)
+
+// Final returns true if the status won't change any more.
+func (status Status) Final() bool {
+	return status == StatusPaid || status == StatusExpired || status == StatusFailed
+}
+
+// statusPollInterval is the interval in which the server-sent events stream queries the PurchaseRepo.
+var statusPollInterval = 5 * time.Second
+
+// statusStreamDuration limits the server-sent events stream, so an abandoned page doesn't hold a connection forever. Then the client falls back to polling.
+var statusStreamDuration = 10 * time.Minute
+
+type statusResponse struct {
+	Status Status `json:"status"`
+}
+
+// purchaseStatus serves the status of the purchase "?purchase-id=...&payment-key=..." as JSON, like {"status": "paid"}.
+// If the client accepts "text/event-stream", the status is sent as server-sent events whenever it changes, until it is final or statusStreamDuration has passed.
+func purchaseStatus(purchases PurchaseRepo) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		purchaseID := r.URL.Query().Get("purchase-id")
+		paymentKey := r.URL.Query().Get("payment-key")
+		if purchases == nil {
+			http.NotFound(w, r)
+			return
+		}
+		if purchaseID == "" {
+			http.Error(w, "missing purchase id", http.StatusBadRequest)
+			return
+		}
+
+		status, err := purchases.PurchaseStatus(purchaseID, paymentKey)
+		if err != nil {
+			log.Printf("[%s] error getting purchase status: %v", purchaseID+":"+paymentKey, err)
+			http.Error(w, "error getting purchase status", http.StatusInternalServerError)
+			return
+		}
+
+		if r.Header.Get("Accept") != "text/event-stream" {
+			w.Header().Set("Cache-Control", "no-store")
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(statusResponse{status})
+			return
+		}
+
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "streaming not supported", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Cache-Control", "no-store")
+		w.Header().Set("Content-Type", "text/event-stream")
+
+		deadline := time.Now().Add(statusStreamDuration)
+		ctx, cancel := context.WithDeadline(r.Context(), deadline)
+		defer cancel()
+		rc := http.NewResponseController(w)
+		rc.SetWriteDeadline(deadline.Add(statusPollInterval)) // don't block on clients which don't read, errors if not supported
+		defer rc.SetWriteDeadline(time.Time{})                // the connection might be reused
+
+		ticker := time.NewTicker(statusPollInterval)
+		defer ticker.Stop()
+		var sent Status
+		for {
+			if status != sent {
+				data, _ := json.Marshal(statusResponse{status})
+				fmt.Fprintf(w, "data: %s\n\n", data)
+				flusher.Flush()
+				sent = status
+			}
+			if status.Final() {
+				return
+			}
+			select {
+			case <-ctx.Done():
+				return // if the deadline has passed, the client falls back to polling
+			case <-ticker.C:
+			}
+			status, err = purchases.PurchaseStatus(purchaseID, paymentKey)
+			if err != nil {
+				log.Printf("[%s] error getting purchase status: %v", purchaseID+":"+paymentKey, err)
+				return // the client will reconnect
+			}
+		}
+	})
+}
+
+type statusTmplData struct {
+	lang.Lang
+	URL string
+}
+
+// StatusHTML returns an element which shows the status of the purchase and keeps it up to date, using server-sent events or, as a fallback, polling.
+// When the status changes, the element dispatches a "purchase-status" event with the status as detail, so the page can update itself without reloading.
+//
+// The method must be registered as described in the package documentation.
+func StatusHTML(method Method, purchaseID, paymentKey string, l lang.Lang) (template.HTML, error) {
+	query := url.Values{}
+	query.Set("purchase-id", purchaseID)
+	query.Set("payment-key", paymentKey)
+
+	buf := &bytes.Buffer{}
+	err := statusTmpl.Execute(buf, statusTmplData{
+		Lang: l,
+		URL:  fmt.Sprintf("/payment/%s/purchase-status?%s", method.ID(), query.Encode()),
+	})
+	return template.HTML(buf.String()), err
+}
@@ -0,0 +1,96 @@
+package payment
+
+import (
+	"bufio"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dys2p/eco/lang"
+	"golang.org/x/text/language"
+	"golang.org/x/text/message"
+)
+
+func TestPurchaseStatus(t *testing.T) {
+	repo := &testRepo{status: StatusPending}
+	handler := SEPA{Purchases: repo}.Handler()
+
+	if w := serve(handler, http.MethodGet, "/payment/sepa/purchase-status", ""); w.Code != http.StatusBadRequest {
+		t.Fatalf("got status %d, want bad request", w.Code)
+	}
+
+	w := serve(handler, http.MethodGet, "/payment/sepa/purchase-status?purchase-id=ABC&payment-key=key", "")
+	if got := strings.TrimSpace(w.Body.String()); got != `{"status":"pending"}` {
+		t.Fatalf("got %s", got)
+	}
+
+	if w := serve(Cash{}.Handler(), http.MethodGet, "/payment/cash/purchase-status?purchase-id=ABC", ""); w.Code != http.StatusNotFound {
+		t.Fatalf("got status %d, want not found", w.Code)
+	}
+}
+
+func TestPurchaseStatusEvents(t *testing.T) {
+	oldInterval := statusPollInterval
+	t.Cleanup(func() { statusPollInterval = oldInterval })
+	statusPollInterval = 10 * time.Millisecond
+
+	repo := &testRepo{status: StatusPending}
+	srv := httptest.NewServer(Stripe{Purchases: repo}.Handler())
+	defer srv.Close()
+
+	r, _ := http.NewRequest(http.MethodGet, srv.URL+"/payment/stripe/purchase-status?purchase-id=ABC&payment-key=key", nil)
+	r.Header.Set("Accept", "text/event-stream")
+	resp, err := srv.Client().Do(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var got []string
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		if data, ok := strings.CutPrefix(scanner.Text(), "data: "); ok {
+			got = append(got, data)
+			switch len(got) {
+			case 1:
+				repo.setStatus(StatusProcessing)
+			case 2:
+				repo.setStatus(StatusPaid)
+			}
+		}
+	}
+	want := []string{`{"status":"pending"}`, `{"status":"processing"}`, `{"status":"paid"}`}
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Fatalf("got events %q, want %q", got, want)
+	}
+
+	// the stream ends after statusStreamDuration even if the status is not final
+	defer func(d time.Duration) { statusStreamDuration = d }(statusStreamDuration)
+	statusStreamDuration = 50 * time.Millisecond
+	repo.setStatus(StatusPending)
+	resp, err = srv.Client().Do(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.TrimSpace(string(body)); got != `data: {"status":"pending"}` {
+		t.Fatalf("got %q", got)
+	}
+}
+
+func TestStatusHTML(t *testing.T) {
+	html, err := StatusHTML(SEPA{}, "ABC", "k&y", lang.Lang{Printer: message.NewPrinter(language.English)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(html), `"/payment/sepa/purchase-status?payment-key=k%26y\u0026purchase-id=ABC"`) {
+		t.Fatalf("status url not found in %s", html)
+	}
+}
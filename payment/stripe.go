@@ -0,0 +1,377 @@
+package payment
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dys2p/eco/httputil"
+	"github.com/dys2p/eco/lang"
+)
+
+var stripeTmpl = template.Must(template.ParseFS(htmlfiles, "stripe.html"))
+
+type stripeTmplData struct {
+	lang.Lang
+	PurchaseID  string
+	PaymentKey  string
+	RedirectURL string
+}
+
+// Stripe redirects the customer to a Stripe Checkout Session, see https://docs.stripe.com/payments/checkout
+//
+// Set up the webhook for your Stripe account: URL: "/payment/stripe/webhook", events: "checkout.session.completed", "checkout.session.async_payment_succeeded", "charge.refunded".
+// A payment is reported by both the redirect and the webhook, and refunds are reported by both Refund and the webhook. The Ledger makes sure that each is reported once.
+type Stripe struct {
+	APIURL        string  // optional, default "https://api.stripe.com"
+	Ledger        *Ledger // required for the webhook, records each payment and refund, so it is reported once
+	SecretKey     string
+	WebhookSecret string // signing secret of the webhook endpoint, "whsec_...", enables the webhook if Ledger is set
+	Purchases     PurchaseRepo
+
+	Err        func(err error) http.Handler // should write an error message or error template to the ResponseWriter
+	ErrWebhook func(err error) http.Handler
+}
+
+func (s Stripe) Handler() http.Handler {
+	if s.Err == nil {
+		s.Err = func(err error) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				log.Printf("error processing Stripe payment: %v", err)
+				w.Write([]byte("There was an error processing your card payment. We have been notified and will fix it soon. Sorry for the inconvenience."))
+			})
+		}
+	}
+
+	if s.ErrWebhook == nil {
+		s.ErrWebhook = func(err error) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				log.Printf("error processing Stripe webhook: %v", err)
+				w.WriteHeader(http.StatusInternalServerError) // Stripe will retry
+			})
+		}
+	}
+
+	var mux = http.NewServeMux()
+	mux.Handle("POST /payment/stripe/create-session", httputil.HandlerFunc(s.createSession))
+	mux.Handle("GET  /payment/stripe/purchase-status", purchaseStatus(s.Purchases))
+	mux.Handle("GET  /payment/stripe/redirect", httputil.HandlerFunc(s.redirect)) // after payment
+	if s.WebhookSecret != "" && s.Ledger == nil {
+		log.Println("not enabling the Stripe webhook because Ledger is nil")
+	}
+	if s.WebhookSecret != "" && s.Ledger != nil {
+		mux.Handle("POST /payment/stripe/webhook", httputil.HandlerFunc(s.webhook))
+	}
+	return mux
+}
+
+// Currencies returns nil because Stripe supports most currencies. Amounts are passed in the minor unit of the purchase currency.
+func (Stripe) Currencies() []string {
+	return nil
+}
+
+func (Stripe) ID() string {
+	return "stripe"
+}
+
+func (Stripe) Name(l lang.Lang) string {
+	return l.Tr("Credit Card")
+}
+
+func (s Stripe) PayHTML(purchaseID, paymentKey, redirectURL string, l lang.Lang) (template.HTML, error) {
+	buf := &bytes.Buffer{}
+	err := stripeTmpl.Execute(buf, stripeTmplData{
+		Lang:        l,
+		PurchaseID:  purchaseID,
+		PaymentKey:  paymentKey,
+		RedirectURL: redirectURL,
+	})
+	return template.HTML(buf.String()), err
+}
+
+func (Stripe) VerifiesAdult() bool {
+	return false
+}
+
+type stripeSession struct {
+	ID                string            `json:"id"`
+	AmountTotal       int               `json:"amount_total"`
+	ClientReferenceID string            `json:"client_reference_id"`
+	Currency          string            `json:"currency"`
+	PaymentIntent     string            `json:"payment_intent"`
+	PaymentStatus     string            `json:"payment_status"` // "paid", "unpaid" or "no_payment_required"
+	URL               string            `json:"url"`
+	Metadata          map[string]string `json:"metadata"`
+}
+
+type stripeCharge struct {
+	ID             string `json:"id"`
+	AmountRefunded int    `json:"amount_refunded"`
+	PaymentIntent  string `json:"payment_intent"`
+}
+
+type stripePaymentIntent struct {
+	ID       string            `json:"id"`
+	Metadata map[string]string `json:"metadata"`
+}
+
+type stripeRefund struct {
+	ID     string `json:"id"`
+	Amount int    `json:"amount"`
+	Status string `json:"status"`
+}
+
+type stripeRefundList struct {
+	Data    []stripeRefund `json:"data"`
+	HasMore bool           `json:"has_more"`
+}
+
+type stripeEvent struct {
+	ID   string `json:"id"`
+	Type string `json:"type"`
+	Data struct {
+		Object json.RawMessage `json:"object"`
+	} `json:"data"`
+}
+
+func (s Stripe) createSession(w http.ResponseWriter, r *http.Request) http.Handler {
+	purchaseID := r.PostFormValue("purchase-id")
+	paymentKey := r.PostFormValue("payment-key")
+	redirectURL := r.PostFormValue("redirect-url")
+	reference := purchaseID + ":" + paymentKey
+
+	sum, err := PurchaseSum(s.Purchases, purchaseID, paymentKey)
+	if err != nil {
+		return s.Err(fmt.Errorf("getting purchase sum: %w", err))
+	}
+
+	returnURL := absHost(r) + "/payment/stripe/redirect?session={CHECKOUT_SESSION_ID}"
+	form := url.Values{}
+	form.Set("mode", "payment")
+	form.Set("client_reference_id", reference)
+	form.Set("success_url", returnURL)
+	form.Set("cancel_url", returnURL)
+	form.Set("line_items[0][quantity]", "1")
+	form.Set("line_items[0][price_data][currency]", strings.ToLower(sum.Currency))
+	form.Set("line_items[0][price_data][unit_amount]", strconv.Itoa(sum.Minor))
+	form.Set("line_items[0][price_data][product_data][name]", "Purchase "+purchaseID)
+	form.Set("metadata[reference]", reference)
+	form.Set("payment_intent_data[metadata][reference]", reference) // required for refund events
+
+	var session stripeSession
+	if err := s.stripeRequest(http.MethodPost, "/v1/checkout/sessions", form, &session); err != nil {
+		return s.Err(fmt.Errorf("creating checkout session: %w", err))
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     "payment-stripe-redirect-url",
+		Value:    redirectURL,
+		Expires:  time.Now().Add(4 * time.Hour),
+		HttpOnly: true,                 // no javascript
+		SameSite: http.SameSiteLaxMode, // cookie must be sent on the redirect from Stripe
+	})
+
+	return http.RedirectHandler(session.URL, http.StatusSeeOther)
+}
+
+// redirect checks the checkout session and redirects to the purchase (URL stored in cookie).
+//
+// advantage over webhook: this works on localhost
+func (s Stripe) redirect(w http.ResponseWriter, r *http.Request) http.Handler {
+	if sessionID := r.URL.Query().Get("session"); sessionID != "" {
+		var session stripeSession
+		if err := s.stripeRequest(http.MethodGet, "/v1/checkout/sessions/"+url.PathEscape(sessionID), nil, &session); err != nil {
+			return s.Err(fmt.Errorf("getting checkout session: %w", err))
+		}
+		if err := s.settle(session); err != nil {
+			return s.Err(err)
+		}
+	}
+
+	cookie, err := r.Cookie("payment-stripe-redirect-url")
+	if err != nil || cookie.Value == "" {
+		return http.RedirectHandler(absHost(r), http.StatusSeeOther)
+	}
+	return http.RedirectHandler(cookie.Value, http.StatusSeeOther)
+}
+
+// settle reports a paid checkout session to the PurchaseRepo. The payment ID is the payment intent ID. Unpaid sessions are ignored. If s.Ledger is set, each payment intent is reported once.
+func (s Stripe) settle(session stripeSession) error {
+	if session.PaymentStatus != "paid" {
+		return nil
+	}
+	purchaseID, paymentKey, _ := strings.Cut(session.ClientReferenceID, ":")
+	sum, err := PurchaseSum(s.Purchases, purchaseID, paymentKey)
+	if err != nil {
+		return fmt.Errorf("getting purchase sum: %w", err)
+	}
+	if !strings.EqualFold(session.Currency, sum.Currency) {
+		return fmt.Errorf("checkout session %s has unexpected currency: %s", session.ID, session.Currency)
+	}
+
+	log.Printf("[%s] paid checkout session: %s, payment intent: %s", session.ClientReferenceID, session.ID, session.PaymentIntent)
+
+	return s.Ledger.capture(s.Purchases, "Stripe", purchaseID, paymentKey, session.PaymentIntent, session.AmountTotal)
+}
+
+func (s Stripe) webhook(w http.ResponseWriter, r *http.Request) http.Handler {
+	payload, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+	if err != nil {
+		return s.ErrWebhook(fmt.Errorf("reading body: %w", err))
+	}
+	if err := verifyStripeSignature(payload, r.Header.Get("Stripe-Signature"), s.WebhookSecret, time.Now()); err != nil {
+		return s.ErrWebhook(err)
+	}
+
+	var event stripeEvent
+	if err := json.Unmarshal(payload, &event); err != nil {
+		return s.ErrWebhook(fmt.Errorf("decoding event: %w", err))
+	}
+
+	switch event.Type {
+	case "checkout.session.completed", "checkout.session.async_payment_succeeded":
+		var session stripeSession
+		if err := json.Unmarshal(event.Data.Object, &session); err != nil {
+			return s.ErrWebhook(fmt.Errorf("decoding checkout session of event %s: %w", event.ID, err))
+		}
+		if err := s.settle(session); err != nil {
+			return s.ErrWebhook(err)
+		}
+	case "charge.refunded":
+		var charge stripeCharge
+		if err := json.Unmarshal(event.Data.Object, &charge); err != nil {
+			return s.ErrWebhook(fmt.Errorf("decoding charge of event %s: %w", event.ID, err))
+		}
+		if err := s.refunded(charge); err != nil {
+			return s.ErrWebhook(err)
+		}
+	default:
+		log.Printf("ignoring Stripe webhook event %s of type %s", event.ID, event.Type)
+	}
+	return nil
+}
+
+// refunded reports all refunds of a charge to the PurchaseRepo. Refunds which have been reported before are skipped by s.Ledger.
+func (s Stripe) refunded(charge stripeCharge) error {
+	var intent stripePaymentIntent
+	if err := s.stripeRequest(http.MethodGet, "/v1/payment_intents/"+url.PathEscape(charge.PaymentIntent), nil, &intent); err != nil {
+		return fmt.Errorf("getting payment intent of charge %s: %w", charge.ID, err)
+	}
+	reference := intent.Metadata["reference"]
+	if reference == "" {
+		return fmt.Errorf("payment intent %s has no reference", intent.ID)
+	}
+	purchaseID, paymentKey, _ := strings.Cut(reference, ":")
+
+	var refunds stripeRefundList
+	if err := s.stripeRequest(http.MethodGet, "/v1/refunds?limit=100&charge="+url.QueryEscape(charge.ID), nil, &refunds); err != nil {
+		return fmt.Errorf("listing refunds of charge %s: %w", charge.ID, err)
+	}
+	for _, refund := range refunds.Data {
+		if refund.Status != "succeeded" && refund.Status != "pending" {
+			continue
+		}
+		if err := s.Ledger.refund(s.Purchases, "Stripe", purchaseID, paymentKey, intent.ID, refund.ID, refund.Amount); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Refund refunds a Stripe payment. The payment ID is the payment intent ID.
+func (s Stripe) Refund(purchaseID, paymentKey, paymentID string, cents int) (Refund, error) {
+	if cents < 0 {
+		return Refund{}, fmt.Errorf("invalid refund amount: %d", cents)
+	}
+
+	form := url.Values{}
+	form.Set("payment_intent", paymentID)
+	if cents > 0 {
+		form.Set("amount", strconv.Itoa(cents))
+	}
+	var stripeRef stripeRefund
+	if err := s.stripeRequest(http.MethodPost, "/v1/refunds", form, &stripeRef); err != nil {
+		return Refund{}, fmt.Errorf("refunding payment intent %s: %w", paymentID, err)
+	}
+
+	refund := Refund{
+		ID:    stripeRef.ID,
+		Cents: stripeRef.Amount,
+	}
+	log.Printf("[%s] refunded payment intent: %s, refund: %s, status: %s", purchaseID+":"+paymentKey, paymentID, refund.ID, stripeRef.Status)
+
+	if err := s.Ledger.refund(s.Purchases, "Stripe", purchaseID, paymentKey, paymentID, refund.ID, refund.Cents); err != nil {
+		return refund, err
+	}
+	return refund, nil
+}
+
+// stripeRequest performs a Stripe API request with an optional form-encoded body and unmarshals the JSON response into result.
+func (s Stripe) stripeRequest(method, path string, form url.Values, result any) error {
+	apiURL := s.APIURL
+	if apiURL == "" {
+		apiURL = "https://api.stripe.com"
+	}
+	var header = http.Header{}
+	header.Set("Authorization", "Bearer "+s.SecretKey)
+	var body any
+	if form != nil {
+		body = form
+	}
+	return doJSON(method, strings.TrimSuffix(apiURL, "/")+path, header, body, result)
+}
+
+// stripeSignatureTolerance is the maximum age of a webhook event, which protects against replay attacks.
+const stripeSignatureTolerance = 5 * time.Minute
+
+// verifyStripeSignature checks the "Stripe-Signature" header, like "t=1492774577,v1=5257a869...", see https://docs.stripe.com/webhooks#verify-manually
+func verifyStripeSignature(payload []byte, header, secret string, now time.Time) error {
+	if secret == "" {
+		return errors.New("missing webhook secret")
+	}
+	var timestamp string
+	var signatures [][]byte
+	for _, item := range strings.Split(header, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(item), "=")
+		switch key {
+		case "t":
+			timestamp = value
+		case "v1":
+			if sig, err := hex.DecodeString(value); err == nil {
+				signatures = append(signatures, sig)
+			}
+		}
+	}
+
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return errors.New("missing or invalid signature timestamp")
+	}
+	if age := now.Sub(time.Unix(seconds, 0)); age > stripeSignatureTolerance || age < -stripeSignatureTolerance {
+		return fmt.Errorf("signature timestamp out of tolerance: %v", age)
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(payload)
+	expected := mac.Sum(nil)
+	for _, sig := range signatures {
+		if hmac.Equal(sig, expected) {
+			return nil
+		}
+	}
+	return errors.New("invalid signature")
+}
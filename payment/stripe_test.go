@@ -0,0 +1,168 @@
+package payment
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func signStripe(payload, secret string, t time.Time) string {
+	timestamp := fmt.Sprint(t.Unix())
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "." + payload))
+	return "t=" + timestamp + ",v1=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestStripe(t *testing.T) {
+	var refunds = `{"id": "re_1", "amount": 200, "status": "succeeded"}, {"id": "re_2", "amount": 100, "status": "failed"}`
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /v1/checkout/sessions", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer sk_test" {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"error": {"message": "invalid api key"}}`))
+			return
+		}
+		if r.PostFormValue("line_items[0][price_data][unit_amount]") != "1234" || r.PostFormValue("client_reference_id") != "ABC:key" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.Write([]byte(`{"id": "cs_1", "url": "https://checkout.stripe.com/c/pay/cs_1"}`))
+	})
+	mux.HandleFunc("GET /v1/checkout/sessions/cs_1", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"id": "cs_1", "amount_total": 1234, "client_reference_id": "ABC:key", "currency": "eur", "payment_intent": "pi_1", "payment_status": "paid"}`))
+	})
+	mux.HandleFunc("GET /v1/payment_intents/pi_1", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"id": "pi_1", "metadata": {"reference": "ABC:key"}}`))
+	})
+	mux.HandleFunc("GET /v1/refunds", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("charge") != "ch_1" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write([]byte(`{"data": [` + refunds + `]}`))
+	})
+	mux.HandleFunc("POST /v1/refunds", func(w http.ResponseWriter, r *http.Request) {
+		amount := r.PostFormValue("amount")
+		if amount == "" {
+			amount = "1234"
+		}
+		refunds += `, {"id": "re_3", "amount": ` + amount + `, "status": "succeeded"}`
+		w.Write([]byte(`{"id": "re_3", "amount": ` + amount + `, "status": "succeeded"}`))
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	ledger, err := OpenLedger(filepath.Join(t.TempDir(), "ledger.sqlite3"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	repo := &testRepo{sumCents: 1234}
+	stripe := Stripe{
+		APIURL:        srv.URL,
+		Ledger:        ledger,
+		SecretKey:     "sk_test",
+		WebhookSecret: "whsec_test",
+		Purchases:     repo,
+	}
+	handler := stripe.Handler()
+
+	// create session
+	r := httptest.NewRequest(http.MethodPost, "/payment/stripe/create-session", strings.NewReader("purchase-id=ABC&payment-key=key&redirect-url=/purchase"))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if w.Code != http.StatusSeeOther || w.Header().Get("Location") != "https://checkout.stripe.com/c/pay/cs_1" {
+		t.Fatalf("create session: got %d %s", w.Code, w.Header().Get("Location"))
+	}
+
+	// return from checkout
+	r = httptest.NewRequest(http.MethodGet, "/payment/stripe/redirect?session=cs_1", nil)
+	r.AddCookie(&http.Cookie{Name: "payment-stripe-redirect-url", Value: "/purchase"})
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if w.Code != http.StatusSeeOther || w.Header().Get("Location") != "/purchase" {
+		t.Fatalf("redirect: got %d %s", w.Code, w.Header().Get("Location"))
+	}
+	repo.check(t, "settled ABC:key Stripe pi_1 1234 false", "paid ABC:key Stripe")
+
+	// webhook
+	tests := []struct {
+		event      string
+		sign       func(payload string) string
+		wantStatus int
+		wantCalls  []string
+	}{
+		{
+			`{"id": "evt_1", "type": "checkout.session.completed", "data": {"object": {"id": "cs_1", "amount_total": 1234, "client_reference_id": "ABC:key", "currency": "eur", "payment_intent": "pi_1", "payment_status": "paid"}}}`,
+			func(payload string) string { return signStripe(payload, "whsec_wrong", time.Now()) },
+			http.StatusInternalServerError,
+			nil,
+		},
+		{
+			`{"id": "evt_2", "type": "checkout.session.completed", "data": {"object": {"id": "cs_1", "amount_total": 1234, "client_reference_id": "ABC:key", "currency": "eur", "payment_intent": "pi_1", "payment_status": "paid"}}}`,
+			func(payload string) string { return signStripe(payload, "whsec_test", time.Now().Add(-time.Hour)) },
+			http.StatusInternalServerError,
+			nil,
+		},
+		{
+			`{"id": "evt_3", "type": "checkout.session.completed", "data": {"object": {"id": "cs_1", "amount_total": 1234, "client_reference_id": "ABC:key", "currency": "eur", "payment_intent": "pi_1", "payment_status": "paid"}}}`,
+			func(payload string) string { return signStripe(payload, "whsec_test", time.Now()) },
+			http.StatusOK,
+			nil, // settled on redirect already
+		},
+		{
+			`{"id": "evt_4", "type": "checkout.session.completed", "data": {"object": {"id": "cs_2", "client_reference_id": "ABC:key", "currency": "eur", "payment_status": "unpaid"}}}`,
+			func(payload string) string { return signStripe(payload, "whsec_test", time.Now()) },
+			http.StatusOK,
+			nil,
+		},
+		{
+			`{"id": "evt_5", "type": "charge.refunded", "data": {"object": {"id": "ch_1", "amount_refunded": 200, "payment_intent": "pi_1"}}}`,
+			func(payload string) string { return "t=0,v1=00," + signStripe(payload, "whsec_test", time.Now()) },
+			http.StatusOK,
+			[]string{"refunded ABC:key Stripe pi_1 re_1 200"},
+		},
+		{
+			`{"id": "evt_5", "type": "charge.refunded", "data": {"object": {"id": "ch_1", "amount_refunded": 200, "payment_intent": "pi_1"}}}`,
+			func(payload string) string { return signStripe(payload, "whsec_test", time.Now()) },
+			http.StatusOK,
+			nil, // delivered again
+		},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest(http.MethodPost, "/payment/stripe/webhook", strings.NewReader(test.event))
+		r.Header.Set("Stripe-Signature", test.sign(test.event))
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if w.Code != test.wantStatus {
+			t.Fatalf("got status %d, want %d", w.Code, test.wantStatus)
+		}
+		repo.check(t, test.wantCalls...)
+	}
+
+	// refund
+	refund, err := stripe.Refund("ABC", "key", "pi_1", 0)
+	if err != nil || refund.ID != "re_3" || refund.Cents != 1234 {
+		t.Fatalf("got %+v %v", refund, err)
+	}
+	repo.check(t, "refunded ABC:key Stripe pi_1 re_3 1234")
+
+	// the webhook of the refund does not report it again
+	event := `{"id": "evt_6", "type": "charge.refunded", "data": {"object": {"id": "ch_1", "amount_refunded": 1434, "payment_intent": "pi_1"}}}`
+	r = httptest.NewRequest(http.MethodPost, "/payment/stripe/webhook", strings.NewReader(event))
+	r.Header.Set("Stripe-Signature", signStripe(event, "whsec_test", time.Now()))
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d", w.Code)
+	}
+	repo.check(t)
+}
@@ -0,0 +1,327 @@
+package payment
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dys2p/eco/id"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+var (
+	ErrVoucherBlocked  = errors.New("voucher has been blocked")
+	ErrVoucherEmpty    = errors.New("voucher has no balance left")
+	ErrVoucherExpired  = errors.New("voucher has expired")
+	ErrVoucherNotFound = errors.New("voucher not found")
+)
+
+// An IssuedVoucher is a gift voucher with its balance history.
+type IssuedVoucher struct {
+	Code         string
+	BalanceCents int
+	Expires      string // yyyy-mm-dd, the voucher is valid until the end of this day
+	Blocked      bool
+	History      []VoucherEntry
+}
+
+// A VoucherEntry changes the balance of a voucher.
+type VoucherEntry struct {
+	ID         int // redemptions: the payment ID which is passed to PurchaseRepo.PaymentSettled
+	Time       time.Time
+	Cents      int    // positive when the voucher is issued or a redemption is refunded, negative when it is redeemed
+	PurchaseID string // redemptions and refunds only
+	PaymentKey string // redemptions and refunds only
+	RefundOf   int    // refunds only, ID of the redemption
+}
+
+// VoucherStore stores vouchers and their balance history in an SQLite database.
+type VoucherStore struct {
+	sqldb    *sql.DB
+	balance  *sql.Stmt
+	block    *sql.Stmt
+	entry    *sql.Stmt
+	history  *sql.Stmt
+	insert   *sql.Stmt
+	record   *sql.Stmt
+	redeemed *sql.Stmt
+	refunded *sql.Stmt
+	voucher  *sql.Stmt
+}
+
+func OpenVoucherStore(fpath string) (*VoucherStore, error) {
+	sqldb, err := sql.Open("sqlite3", fpath+"?_busy_timeout=10000&_journal=WAL&_sync=NORMAL&_txlock=immediate&cache=shared")
+	if err != nil {
+		return nil, fmt.Errorf("opening database %s: %v", fpath, err)
+	}
+
+	if _, err := sqldb.Exec(`
+		create table if not exists vouchers (
+			code    text    primary key,
+			expires text    not null, -- yyyy-mm-dd
+			blocked integer not null
+		);
+		create table if not exists voucher_entries (
+			id          integer primary key,
+			code        text    not null references vouchers (code),
+			time        integer not null, -- unix timestamp
+			cents       integer not null,
+			purchase_id text    not null,
+			payment_key text    not null,
+			refund_of   integer not null
+		);
+		create index if not exists voucher_entries_code on voucher_entries (code);
+		create index if not exists voucher_entries_purchase on voucher_entries (purchase_id, payment_key);
+	`); err != nil {
+		return nil, err
+	}
+
+	balance, err := sqldb.Prepare("select coalesce(sum(cents), 0) from voucher_entries where code = ?")
+	if err != nil {
+		return nil, err
+	}
+	block, err := sqldb.Prepare("update vouchers set blocked = 1 where code = ?")
+	if err != nil {
+		return nil, err
+	}
+	entry, err := sqldb.Prepare("select id, code, time, cents, purchase_id, payment_key, refund_of from voucher_entries where id = ?")
+	if err != nil {
+		return nil, err
+	}
+	history, err := sqldb.Prepare("select id, code, time, cents, purchase_id, payment_key, refund_of from voucher_entries where code = ? order by id")
+	if err != nil {
+		return nil, err
+	}
+	insert, err := sqldb.Prepare("insert into vouchers (code, expires, blocked) values (?, ?, 0)")
+	if err != nil {
+		return nil, err
+	}
+	record, err := sqldb.Prepare("insert into voucher_entries (code, time, cents, purchase_id, payment_key, refund_of) values (?, ?, ?, ?, ?, ?)")
+	if err != nil {
+		return nil, err
+	}
+	redeemed, err := sqldb.Prepare("select coalesce(-sum(cents), 0) from voucher_entries where purchase_id = ? and payment_key = ?")
+	if err != nil {
+		return nil, err
+	}
+	refunded, err := sqldb.Prepare("select coalesce(sum(cents), 0) from voucher_entries where refund_of = ?")
+	if err != nil {
+		return nil, err
+	}
+	voucher, err := sqldb.Prepare("select expires, blocked from vouchers where code = ?")
+	if err != nil {
+		return nil, err
+	}
+
+	return &VoucherStore{
+		sqldb:    sqldb,
+		balance:  balance,
+		block:    block,
+		entry:    entry,
+		history:  history,
+		insert:   insert,
+		record:   record,
+		redeemed: redeemed,
+		refunded: refunded,
+		voucher:  voucher,
+	}, nil
+}
+
+// NormalizeVoucherCode removes whitespace and dashes and converts the code to upper case.
+func NormalizeVoucherCode(code string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '-' || r == ' ' || r == '\t' || r == '\n' || r == '\r' {
+			return -1
+		}
+		return r
+	}, strings.ToUpper(code))
+}
+
+// Create issues a voucher and returns its code. The code consists of 12 case-insensitive characters.
+func (store *VoucherStore) Create(cents int, expires string) (string, error) {
+	if cents <= 0 {
+		return "", fmt.Errorf("invalid voucher amount: %d", cents)
+	}
+	if _, err := time.Parse("2006-01-02", expires); err != nil {
+		return "", fmt.Errorf("invalid expiry date: %w", err)
+	}
+
+	tx, err := store.sqldb.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	for range 5 { // see id.New
+		code := id.New(12, id.AlphanumCaseInsensitiveDigits)
+		if _, err := tx.Stmt(store.insert).Exec(code, expires); err != nil {
+			continue // probably not unique
+		}
+		if _, err := tx.Stmt(store.record).Exec(code, time.Now().Unix(), cents, "", "", 0); err != nil {
+			return "", err
+		}
+		return code, tx.Commit()
+	}
+	return "", errors.New("creating voucher code failed five times")
+}
+
+// Get returns a voucher with its history.
+func (store *VoucherStore) Get(code string) (IssuedVoucher, error) {
+	voucher := IssuedVoucher{Code: NormalizeVoucherCode(code)}
+	switch err := store.voucher.QueryRow(voucher.Code).Scan(&voucher.Expires, &voucher.Blocked); err {
+	case nil:
+	case sql.ErrNoRows:
+		return IssuedVoucher{}, ErrVoucherNotFound
+	default:
+		return IssuedVoucher{}, err
+	}
+
+	rows, err := store.history.Query(voucher.Code)
+	if err != nil {
+		return IssuedVoucher{}, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		entry, err := scanVoucherEntry(rows)
+		if err != nil {
+			return IssuedVoucher{}, err
+		}
+		voucher.BalanceCents += entry.Cents
+		voucher.History = append(voucher.History, entry.VoucherEntry)
+	}
+	return voucher, rows.Err()
+}
+
+// Block blocks a voucher, for example if it has been stolen. Blocked vouchers can't be redeemed.
+func (store *VoucherStore) Block(code string) error {
+	result, err := store.block.Exec(NormalizeVoucherCode(code))
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrVoucherNotFound
+	}
+	return nil
+}
+
+// RedeemedCents returns the amount which has been redeemed for a purchase, minus refunds.
+func (store *VoucherStore) RedeemedCents(purchaseID, paymentKey string) (int, error) {
+	var cents int
+	return cents, store.redeemed.QueryRow(purchaseID, paymentKey).Scan(&cents)
+}
+
+// redeem redeems the voucher for the part of sumCents which has not been redeemed yet. It returns the entry ID and the redeemed amount.
+func (store *VoucherStore) redeem(code, purchaseID, paymentKey string, sumCents int, now time.Time) (int, int, error) {
+	code = NormalizeVoucherCode(code)
+
+	tx, err := store.sqldb.Begin()
+	if err != nil {
+		return 0, 0, err
+	}
+	defer tx.Rollback()
+
+	var expires string
+	var blocked bool
+	switch err := tx.Stmt(store.voucher).QueryRow(code).Scan(&expires, &blocked); err {
+	case nil:
+	case sql.ErrNoRows:
+		return 0, 0, ErrVoucherNotFound
+	default:
+		return 0, 0, err
+	}
+	if blocked {
+		return 0, 0, ErrVoucherBlocked
+	}
+	if now.Format("2006-01-02") > expires {
+		return 0, 0, ErrVoucherExpired
+	}
+
+	var balance int
+	if err := tx.Stmt(store.balance).QueryRow(code).Scan(&balance); err != nil {
+		return 0, 0, err
+	}
+	if balance <= 0 {
+		return 0, 0, ErrVoucherEmpty
+	}
+	var redeemed int
+	if err := tx.Stmt(store.redeemed).QueryRow(purchaseID, paymentKey).Scan(&redeemed); err != nil {
+		return 0, 0, err
+	}
+	if sumCents-redeemed <= 0 {
+		return 0, 0, errors.New("purchase has already been paid with vouchers")
+	}
+	cents := min(balance, sumCents-redeemed)
+
+	result, err := tx.Stmt(store.record).Exec(code, now.Unix(), -cents, purchaseID, paymentKey, 0)
+	if err != nil {
+		return 0, 0, err
+	}
+	entryID, err := result.LastInsertId()
+	if err != nil {
+		return 0, 0, err
+	}
+	return int(entryID), cents, tx.Commit()
+}
+
+// refund credits up to cents of a redemption back to the voucher. If cents is zero, the remaining redemption is refunded.
+// It returns the entry ID of the refund and the refunded amount.
+func (store *VoucherStore) refund(redemptionID int, cents int, now time.Time) (int, int, error) {
+	tx, err := store.sqldb.Begin()
+	if err != nil {
+		return 0, 0, err
+	}
+	defer tx.Rollback()
+
+	redemption, err := scanVoucherEntry(tx.Stmt(store.entry).QueryRow(redemptionID))
+	if err != nil {
+		return 0, 0, fmt.Errorf("getting redemption %d: %w", redemptionID, err)
+	}
+	if redemption.Cents >= 0 {
+		return 0, 0, fmt.Errorf("voucher entry %d is not a redemption", redemptionID)
+	}
+
+	var refunded int
+	if err := tx.Stmt(store.refunded).QueryRow(redemptionID).Scan(&refunded); err != nil {
+		return 0, 0, err
+	}
+	refundable := -redemption.Cents - refunded
+	if cents == 0 {
+		cents = refundable
+	}
+	if cents <= 0 || cents > refundable {
+		return 0, 0, fmt.Errorf("invalid refund amount: %d, refundable: %d", cents, refundable)
+	}
+
+	result, err := tx.Stmt(store.record).Exec(redemption.code, now.Unix(), cents, redemption.PurchaseID, redemption.PaymentKey, redemptionID)
+	if err != nil {
+		return 0, 0, err
+	}
+	entryID, err := result.LastInsertId()
+	if err != nil {
+		return 0, 0, err
+	}
+	return int(entryID), cents, tx.Commit()
+}
+
+type scannedVoucherEntry struct {
+	VoucherEntry
+	code string
+}
+
+func scanVoucherEntry(row interface{ Scan(...any) error }) (scannedVoucherEntry, error) {
+	var entry scannedVoucherEntry
+	var unix int64
+	err := row.Scan(&entry.ID, &entry.code, &unix, &entry.Cents, &entry.PurchaseID, &entry.PaymentKey, &entry.RefundOf)
+	entry.Time = time.Unix(unix, 0)
+	return entry, err
+}
+
+// voucherPaymentID returns the payment ID of a redemption.
+func voucherPaymentID(entryID int) string {
+	return strconv.Itoa(entryID)
+}
@@ -0,0 +1,179 @@
+package payment
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dys2p/eco"
+	"github.com/dys2p/eco/httputil"
+	"github.com/dys2p/eco/lang"
+)
+
+var voucherTmpl = template.Must(template.ParseFS(htmlfiles, "voucher.html"))
+
+type voucherTmplData struct {
+	lang.Lang
+	PurchaseID    string
+	PaymentKey    string
+	RedirectURL   string
+	RedeemedHTML  template.HTML // empty if nothing has been redeemed
+	RemainingHTML template.HTML
+}
+
+// Voucher redeems gift vouchers from a VoucherStore. Vouchers are in EUR.
+//
+// A voucher can be redeemed fully or partly. If it does not cover the purchase sum, the customer can redeem another voucher or pay the remaining amount with another method.
+// Use Split for that, so each method collects the remaining amount only:
+//
+//	split := payment.Split{
+//		Reconciler: payment.Reconciler{Ledger: ledger, Purchases: repo},
+//	}
+//	methods := []payment.Method{
+//		payment.Voucher{Store: vouchers, Purchases: split.Wrap(repo, "Voucher")},
+//		payment.SEPA{Account: account, Purchases: split.Wrap(repo, "SEPA")},
+//	}
+type Voucher struct {
+	Store     *VoucherStore
+	Purchases PurchaseRepo // should be wrapped with Split.Wrap if other methods can pay the rest
+
+	Err func(err error) http.Handler // should write an error message or error template to the ResponseWriter, note that errors like ErrVoucherExpired are caused by the customer
+}
+
+func (v Voucher) Handler() http.Handler {
+	if v.Err == nil {
+		v.Err = func(err error) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch {
+				case errors.Is(err, ErrVoucherBlocked), errors.Is(err, ErrVoucherEmpty), errors.Is(err, ErrVoucherExpired), errors.Is(err, ErrVoucherNotFound):
+					http.Error(w, "Error: "+err.Error(), http.StatusBadRequest)
+				default:
+					log.Printf("error redeeming voucher: %v", err)
+					http.Error(w, "There was an error redeeming your voucher. We have been notified and will fix it soon. Sorry for the inconvenience.", http.StatusInternalServerError)
+				}
+			})
+		}
+	}
+
+	var mux = http.NewServeMux()
+	mux.Handle("GET  /payment/voucher/purchase-status", purchaseStatus(v.Purchases))
+	mux.Handle("POST /payment/voucher/redeem", httputil.HandlerFunc(v.redeem))
+	return mux
+}
+
+// Currencies returns EUR only because vouchers hold EUR amounts. Then Eligibility hides the method for purchases in other currencies.
+func (Voucher) Currencies() []string {
+	return []string{"EUR"}
+}
+
+func (Voucher) ID() string {
+	return "voucher"
+}
+
+func (Voucher) Name(l lang.Lang) string {
+	return l.Tr("Gift Voucher")
+}
+
+func (v Voucher) PayHTML(purchaseID, paymentKey, redirectURL string, l lang.Lang) (template.HTML, error) {
+	sumCents, err := eurSum(v.Purchases, purchaseID, paymentKey)
+	if err != nil {
+		log.Printf("error getting purchase sum from database: %v", err)
+		return template.HTML("Error getting purchase information from database"), nil
+	}
+	redeemedCents, err := v.Store.RedeemedCents(purchaseID, paymentKey)
+	if err != nil {
+		log.Printf("error getting redeemed vouchers from database: %v", err)
+		return template.HTML("Error getting voucher information from database"), nil
+	}
+
+	data := voucherTmplData{
+		Lang:          l,
+		PurchaseID:    purchaseID,
+		PaymentKey:    paymentKey,
+		RedirectURL:   redirectURL,
+		RemainingHTML: eco.FmtEuroHTML(max(0, sumCents-redeemedCents)),
+	}
+	if redeemedCents > 0 {
+		data.RedeemedHTML = eco.FmtEuroHTML(redeemedCents)
+	}
+
+	buf := &bytes.Buffer{}
+	err = voucherTmpl.Execute(buf, data)
+	return template.HTML(buf.String()), err
+}
+
+func (Voucher) VerifiesAdult() bool {
+	return false
+}
+
+func (v Voucher) redeem(w http.ResponseWriter, r *http.Request) http.Handler {
+	var (
+		purchaseID  = r.PostFormValue("purchase-id")
+		paymentKey  = r.PostFormValue("payment-key")
+		redirectURL = r.PostFormValue("redirect-url")
+		code        = r.PostFormValue("code")
+	)
+	if !strings.HasPrefix(redirectURL, "/") || strings.HasPrefix(redirectURL, "//") {
+		redirectURL = absHost(r) // no open redirect
+	}
+
+	sumCents, err := eurSum(v.Purchases, purchaseID, paymentKey)
+	if err != nil {
+		return v.Err(fmt.Errorf("getting purchase sum: %w", err))
+	}
+
+	entryID, cents, err := v.Store.redeem(code, purchaseID, paymentKey, sumCents, time.Now())
+	if err != nil {
+		return v.Err(err)
+	}
+	paymentID := voucherPaymentID(entryID)
+
+	log.Printf("[%s] redeemed voucher: %s, amount: %d", purchaseID+":"+paymentKey, paymentID, cents)
+
+	if err := v.Purchases.PaymentSettled(purchaseID, paymentKey, "Voucher", paymentID, cents, false); err != nil {
+		if _, _, refundErr := v.Store.refund(entryID, 0, time.Now()); refundErr != nil {
+			err = fmt.Errorf("%w (and reverting redemption %s: %v)", err, paymentID, refundErr)
+		}
+		return v.Err(err)
+	}
+
+	redeemedCents, err := v.Store.RedeemedCents(purchaseID, paymentKey)
+	if err != nil {
+		return v.Err(err)
+	}
+	if redeemedCents >= sumCents {
+		if err := v.Purchases.SetPurchasePaid(purchaseID, paymentKey, "Voucher"); err != nil {
+			return v.Err(err)
+		}
+	}
+	return http.RedirectHandler(redirectURL, http.StatusSeeOther)
+}
+
+// Refund credits a redemption back to the voucher. The payment ID is the ID of the redemption.
+func (v Voucher) Refund(purchaseID, paymentKey, paymentID string, cents int) (Refund, error) {
+	entryID, err := strconv.Atoi(paymentID)
+	if err != nil {
+		return Refund{}, fmt.Errorf("invalid voucher payment ID: %s", paymentID)
+	}
+	refundID, refundedCents, err := v.Store.refund(entryID, cents, time.Now())
+	if err != nil {
+		return Refund{}, err
+	}
+
+	log.Printf("[%s] refunded voucher redemption: %s, refund: %d", purchaseID+":"+paymentKey, paymentID, refundID)
+
+	refund := Refund{
+		ID:    voucherPaymentID(refundID),
+		Cents: refundedCents,
+	}
+	if err := v.Purchases.PaymentRefunded(purchaseID, paymentKey, "Voucher", paymentID, refund.ID, refund.Cents); err != nil {
+		return refund, err
+	}
+	return refund, nil
+}
@@ -0,0 +1,107 @@
+package payment
+
+import (
+	"errors"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dys2p/eco/lang"
+	"golang.org/x/text/language"
+	"golang.org/x/text/message"
+)
+
+func TestVoucher(t *testing.T) {
+	store, err := OpenVoucherStore(filepath.Join(t.TempDir(), "vouchers.sqlite3"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expires := time.Now().AddDate(1, 0, 0).Format("2006-01-02")
+	first, err := store.Create(1000, expires)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := store.Create(2000, expires)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	repo := &testRepo{sumCents: 1500}
+	v := Voucher{Store: store, Purchases: repo}
+	handler := v.Handler()
+	l := lang.Lang{Printer: message.NewPrinter(language.English)}
+
+	// partial redemption, lower case code with dashes
+	w := serve(handler, http.MethodPost, "/payment/voucher/redeem", "purchase-id=ABC&payment-key=key&redirect-url=/purchase&code="+strings.ToLower(first[:4]+"-"+first[4:]))
+	if w.Code != http.StatusSeeOther || w.Header().Get("Location") != "/purchase" {
+		t.Fatalf("got %d %s", w.Code, w.Header().Get("Location"))
+	}
+	repo.check(t, "settled ABC:key Voucher 3 1000 false")
+
+	html, err := v.PayHTML("ABC", "key", "/purchase", l)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(html), "5,00") {
+		t.Fatalf("remaining amount not found in %s", html)
+	}
+
+	// the second voucher pays the remaining amount
+	w = serve(handler, http.MethodPost, "/payment/voucher/redeem", "purchase-id=ABC&payment-key=key&redirect-url=https://attacker.example.net&code="+second)
+	if w.Code != http.StatusSeeOther || w.Header().Get("Location") != "https://example.com" {
+		t.Fatalf("got %d %s", w.Code, w.Header().Get("Location"))
+	}
+	repo.check(t, "settled ABC:key Voucher 4 500 false", "paid ABC:key Voucher")
+
+	voucher, err := store.Get(second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if voucher.BalanceCents != 1500 || len(voucher.History) != 2 || voucher.History[1].Cents != -500 || voucher.History[1].PurchaseID != "ABC" {
+		t.Fatalf("got voucher %+v", voucher)
+	}
+
+	// the first voucher is empty now
+	w = serve(handler, http.MethodPost, "/payment/voucher/redeem", "purchase-id=DEF&payment-key=key&code="+first)
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), ErrVoucherEmpty.Error()) {
+		t.Fatalf("got %d %s", w.Code, w.Body)
+	}
+	repo.check(t)
+
+	// refund
+	refund, err := v.Refund("ABC", "key", "4", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if refund.Cents != 500 {
+		t.Fatalf("got refund %+v", refund)
+	}
+	repo.check(t, "refunded ABC:key Voucher 4 "+refund.ID+" 500")
+	if _, err := v.Refund("ABC", "key", "4", 0); err == nil {
+		t.Fatal("refunded twice")
+	}
+	if voucher, _ := store.Get(second); voucher.BalanceCents != 2000 {
+		t.Fatalf("got balance %d, want 2000", voucher.BalanceCents)
+	}
+
+	// blocked and expired vouchers
+	if err := store.Block(second); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := store.redeem(second, "DEF", "key", 1000, time.Now()); !errors.Is(err, ErrVoucherBlocked) {
+		t.Fatalf("got %v, want ErrVoucherBlocked", err)
+	}
+	expired, err := store.Create(1000, time.Now().AddDate(0, 0, -1).Format("2006-01-02"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := store.redeem(expired, "DEF", "key", 1000, time.Now()); !errors.Is(err, ErrVoucherExpired) {
+		t.Fatalf("got %v, want ErrVoucherExpired", err)
+	}
+	if _, _, err := store.redeem("NOTEXISTING", "DEF", "key", 1000, time.Now()); !errors.Is(err, ErrVoucherNotFound) {
+		t.Fatalf("got %v, want ErrVoucherNotFound", err)
+	}
+}